@@ -1,6 +1,11 @@
 package buffer
 
 import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/nfphys/simpledb-go/file"
 	"github.com/nfphys/simpledb-go/log"
 )
@@ -11,7 +16,15 @@ type Buffer struct {
 	contents *file.Page
 	blk *file.BlockId
 	pins int
-	modified bool
+	txnum int // ページを変更したトランザクション。変更されていなければ -1
+	lsn int // 変更を記録した最新のログレコードの LSN。ログを書いていなければ -1
+	version int64 // SetModified のたびに増える
+	writing bool // 写したページを書き出し中。BufferMgr のロックで守る
+	prefetched bool // 先読みで読み込んでからまだピンされていない。BufferMgr のロックで守る
+	loading bool // 先読みがページを読み込み中。BufferMgr のロックで守る
+	lastAccess time.Time
+	dirtyWrites atomic.Int64 // ディスクに書いた変更済みページの数
+	mu sync.Mutex // txnum, lsn, version を守り、ページの書き出しを 1 つずつにする
 }
 
 func NewBuffer(fm *file.FileMgr, lm *log.LogMgr) *Buffer {
@@ -21,7 +34,8 @@ func NewBuffer(fm *file.FileMgr, lm *log.LogMgr) *Buffer {
 		contents: file.NewPage(fm.BlockSize()),
 		blk: nil,
 		pins: 0,
-		modified: false,
+		txnum: -1,
+		lsn: -1,
 	}
 }
 
@@ -37,22 +51,103 @@ func (b *Buffer) IsPinned() bool {
 	return b.pins > 0
 }
 
-func (b *Buffer) SetModified() {
-	b.modified = true
+// SetModified はページが txnum に変更されたことを記録する。
+// lsn はその変更を記録したログレコードの LSN で、ログを書かない変更なら負の値を渡す。
+func (b *Buffer) SetModified(txnum int, lsn int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.txnum = txnum
+	b.version++
+	if lsn >= 0 {
+		b.lsn = lsn
+		b.contents.SetLSN(lsn)
+	}
+}
+
+// ModifyingTx はページを変更したトランザクションを返す。変更されていなければ -1。
+func (b *Buffer) ModifyingTx() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.txnum
+}
+
+// Flush は変更されたページをディスクに書く。
+// WAL を守るため、先にページの変更を記録したログレコードまでをディスクに書き出す。
+func (b *Buffer) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.txnum >= 0 {
+		if err := b.write(b.blk, b.contents, b.lsn); err != nil {
+			return err
+		}
+		b.txnum = -1
+	}
+	return nil
+}
+
+// pageCopy は bm.mu を外して書くために写したページと、写したときの LSN と版。
+type pageCopy struct {
+	buff *Buffer
+	blk *file.BlockId
+	page *file.Page
+	lsn int
+	version int64
+}
+
+// copyPage はページを写す。b.mu を取った状態で呼ぶ。
+func (b *Buffer) copyPage() *pageCopy {
+	p := file.NewPage(b.fm.BlockSize())
+	p.CopyFrom(b.contents)
+	return &pageCopy{buff: b, blk: b.blk, page: p, lsn: b.lsn, version: b.version}
+}
+
+// writeCopy は copyPage で写したページを書く。写した後に変更されていなければ
+// もう書かなくてよいので変更済みの印を消し、変更されていれば次の書き出しに回す。
+func (b *Buffer) writeCopy(c *pageCopy) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.write(c.blk, c.page, c.lsn); err != nil {
+		return err
+	}
+	if b.version == c.version {
+		b.txnum = -1
+	}
+	return nil
 }
 
-func (b *Buffer) Flush() {
-	if b.modified {
-		b.fm.Write(b.blk, b.contents)
-		b.modified = false
+// write は lsn までのログを書き出してから、p を blk に書く。b.mu を取った状態で呼ぶ。
+func (b *Buffer) write(blk *file.BlockId, p *file.Page, lsn int) error {
+	if lsn >= 0 {
+		if err := b.lm.Flush(lsn); err != nil {
+			return fmt.Errorf("buffer: flush %v: %w", blk, err)
+		}
+	}
+	if err := b.fm.Write(blk, p); err != nil {
+		return fmt.Errorf("buffer: flush %v: %w", blk, err)
 	}
+	if err := b.fm.Sync(blk.FileName()); err != nil {
+		return fmt.Errorf("buffer: flush %v: %w", blk, err)
+	}
+	b.dirtyWrites.Add(1)
+	return nil
 }
 
-func (b *Buffer) assignToBlock(blk *file.BlockId) {
-	b.Flush()
+func (b *Buffer) assignToBlock(blk *file.BlockId) error {
+	if err := b.Flush(); err != nil {
+		return err
+	}
+	if err := b.fm.Read(blk, b.contents); err != nil {
+		b.blk = nil
+		return fmt.Errorf("buffer: assign %v: %w", blk, err)
+	}
 	b.blk = blk
-	b.fm.Read(blk, b.contents)
 	b.pins = 0
+	b.prefetched = false
+	return nil
 }
 
 func (b *Buffer) pin() {
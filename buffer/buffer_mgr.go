@@ -1,7 +1,9 @@
 package buffer
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -10,7 +12,7 @@ import (
 )
 
 const (
-	MAX_TIME = 1000 // 1 seconds
+	MAX_TIME = 1000 // 1 seconds。WithMaxWait で変えられる
 )
 
 var (
@@ -21,28 +23,152 @@ type BufferMgr struct {
 	fm *file.FileMgr
 	lm *log.LogMgr
 	bufferpool []*Buffer
+	pageTable map[file.BlockId]int // ブロックを載せているフレーム
+	replacer Replacer
 	numAvailable int
+	maxWait time.Duration // 0 以下なら期限なしで待つ
+	waiters []*waiter // 空きバッファを待っている Pin。来た順
+	stats Stats
+	pw *pageWriter // nil なら page writer は動かさない
+	readAhead int // 連続した読み込みを見つけたときに先読みするブロック数。0 なら先読みしない
+	scans map[string]*scanState
+	prefetching sync.WaitGroup
+	closed bool
+	writeDone *sync.Cond // バッファの書き出し中や読み込み中が解けたときに Broadcast する
 	mu *sync.Mutex
-	cond *sync.Cond
 }
 
-func NewBufferMgr(fm *file.FileMgr, lm *log.LogMgr, numbuffs int) *BufferMgr {
+// waiter は空きバッファを待つ Pin 1 つ分。先頭の waiter だけが起こされる。
+type waiter struct {
+	ready chan struct{}
+}
+
+func NewBufferMgr(fm *file.FileMgr, lm *log.LogMgr, numbuffs int, opts ...Option) *BufferMgr {
 	bufferpool := make([]*Buffer, numbuffs)
 	for i := 0; i < numbuffs; i++ {
 		bufferpool[i] = NewBuffer(fm, lm)
 	}
 
-	mu := sync.Mutex{}
-	cond := sync.NewCond(&mu)
-
-	return &BufferMgr{
+	bm := &BufferMgr{
 		fm: fm,
 		lm: lm,
 		bufferpool: bufferpool,
+		pageTable: make(map[file.BlockId]int, numbuffs),
+		replacer: Naive(numbuffs),
 		numAvailable: numbuffs,
-		mu: &mu,
-		cond: cond,
+		maxWait: MAX_TIME * time.Millisecond,
+		scans: make(map[string]*scanState),
+		mu: &sync.Mutex{},
 	}
+	bm.writeDone = sync.NewCond(bm.mu)
+	for _, opt := range opts {
+		opt(bm)
+	}
+	if bm.pw != nil {
+		go bm.runPageWriter()
+	}
+
+	return bm
+}
+
+// FlushAll は txnum が変更したバッファをすべてディスクに書く。
+func (bm *BufferMgr) FlushAll(txnum int) error {
+	return bm.flushMatching(func(buff *Buffer) bool {
+		return buff.txnum == txnum
+	})
+}
+
+// FlushFile は filename のブロックを載せた変更済みのバッファをすべてディスクに書く。
+// ピンされたバッファも書くので、チェックポイントなど更新が止まっているときに使う。
+func (bm *BufferMgr) FlushFile(filename string) error {
+	return bm.flushMatching(func(buff *Buffer) bool {
+		return buff.blk != nil && buff.blk.FileName() == filename
+	})
+}
+
+// flushMatching は match が true を返す変更済みのバッファをすべてディスクに書く。
+// match は bm.mu と buff.mu を取った状態で呼ばれる。
+// 対象のページを写して書き出し中にしてから bm.mu を外し、ログを 1 回だけ書き出してから
+// 写しを書くので、group commit を待っている間も Pin や他のトランザクションのコミットは止まらず、
+// 書いている間にページが変更されても、その変更がログより先にディスクに届くことはない。
+func (bm *BufferMgr) flushMatching(match func(buff *Buffer) bool) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	candidates := bm.bufferpool
+	for len(candidates) > 0 {
+		var copies []*pageCopy
+		var busy []*Buffer
+		lsn := -1
+		for _, buff := range candidates {
+			if buff.writing {
+				busy = append(busy, buff)
+				continue
+			}
+			buff.mu.Lock()
+			if buff.txnum >= 0 && match(buff) {
+				buff.writing = true
+				copies = append(copies, buff.copyPage())
+				lsn = max(lsn, buff.lsn)
+			}
+			buff.mu.Unlock()
+		}
+
+		bm.mu.Unlock()
+		err := writeCopies(bm.lm, copies, lsn)
+		bm.mu.Lock()
+		for _, c := range copies {
+			c.buff.writing = false
+		}
+		bm.writeDone.Broadcast()
+		bm.wakeNext()
+		if err != nil {
+			return err
+		}
+
+		// 他が書き出し中だったバッファは、書き終わってから調べ直す
+		for _, buff := range busy {
+			for buff.writing {
+				bm.writeDone.Wait()
+			}
+		}
+		candidates = busy
+	}
+	return nil
+}
+
+// writeCopies は lsn までのログを書き出してから、写したページを書く。
+func writeCopies(lm *log.LogMgr, copies []*pageCopy, lsn int) error {
+	if lsn >= 0 {
+		if err := lm.Flush(lsn); err != nil {
+			return fmt.Errorf("buffer: flush: %w", err)
+		}
+	}
+	for _, c := range copies {
+		if err := c.buff.writeCopy(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close は page writer を止め、動いている先読みが終わるのを待つ。
+// 変更済みのバッファは書かないので、必要なら先に FlushAll や FlushFile を呼ぶこと。
+func (bm *BufferMgr) Close() {
+	bm.mu.Lock()
+	bm.closed = true
+	bm.mu.Unlock()
+
+	bm.prefetching.Wait()
+	if bm.pw == nil {
+		return
+	}
+	select {
+	case <-bm.pw.stop:
+	default:
+		close(bm.pw.stop)
+	}
+	<-bm.pw.done
 }
 
 func (bm *BufferMgr) Available() int {
@@ -59,71 +185,236 @@ func (bm *BufferMgr) Unpin(buff *Buffer) {
 	buff.unpin()
 	if (!buff.IsPinned()) {
 		bm.numAvailable++
-		bm.cond.Broadcast() // signal all waiting threads
+		bm.wakeNext()
 	}
 }
 
+// Waiters は空きバッファを待っている Pin の数を返す。
+func (bm *BufferMgr) Waiters() int {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	return len(bm.waiters)
+}
+
+// Pin は blk をバッファにピンする。空きバッファがなければ、既定では MAX_TIME ミリ秒
+// (WithMaxWait で変えられる) まで待ち、それでも空かなければ ErrBufferNotFound を返す。
 func (bm *BufferMgr) Pin(blk *file.BlockId) (*Buffer, error) {
+	return bm.PinContext(context.Background(), blk)
+}
+
+// PinContext は ctx が終わるまで空きバッファを待つ Pin。
+// ctx に期限がなければ Pin と同じ既定の時間だけ待つ。
+// 待っている Pin は来た順にバッファを受け取るので、後から来た Pin に追い越されない。
+func (bm *BufferMgr) PinContext(ctx context.Context, blk *file.BlockId) (*Buffer, error) {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 
-	buff := bm.tryToPin(blk)
-	if buff != nil {
-		return buff, nil
+	if len(bm.waiters) == 0 || bm.isPinned(blk) {
+		buff, err := bm.tryToPin(blk)
+		if err != nil || buff != nil {
+			return buff, err
+		}
 	}
 
-	timeout := time.After(MAX_TIME * time.Millisecond)
-	go func() {
-		<-timeout
-		bm.cond.Broadcast() // signal all waiting threads
-	}()
-	
+	parent := ctx
+	if _, ok := ctx.Deadline(); !ok && bm.maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, bm.maxWait)
+		defer cancel()
+	}
+
+	w := &waiter{ready: make(chan struct{}, 1)}
+	bm.waiters = append(bm.waiters, w)
+	bm.stats.PinWaits++
 	start := time.Now()
-	for buff == nil && time.Since(start) < MAX_TIME*time.Millisecond {
-		bm.cond.Wait() // release the lock and wait for a signal
-		buff = bm.tryToPin(blk)
+	defer func() {
+		bm.stats.TotalWait += time.Since(start)
+	}()
+	if len(bm.waiters) == 1 && bm.numAvailable > 0 {
+		bm.wakeNext()
 	}
 
-	if buff == nil {
-		return nil, ErrBufferNotFound
+	for {
+		bm.mu.Unlock()
+		select {
+		case <-w.ready:
+			bm.mu.Lock()
+		case <-ctx.Done():
+			bm.mu.Lock()
+			bm.removeWaiter(w)
+			bm.stats.WaitTimeouts++
+			if parent.Err() == nil {
+				return nil, fmt.Errorf("buffer: pin %v: %w", blk, ErrBufferNotFound) // 既定の待ち時間が過ぎた
+			}
+			return nil, fmt.Errorf("buffer: pin %v: %w: %w", blk, ErrBufferNotFound, ctx.Err())
+		}
+
+		buff, err := bm.tryToPin(blk)
+		if err != nil || buff != nil {
+			bm.removeWaiter(w)
+			return buff, err
+		}
 	}
+}
 
-	return buff, nil
+// isPinned は blk がすでにピンされたバッファに載っているかを返す。
+// その場合は空きバッファを使わないので、待っている Pin がいても先にピンしてよい。
+func (bm *BufferMgr) isPinned(blk *file.BlockId) bool {
+	frame := bm.findExistingBuffer(blk)
+	return frame >= 0 && bm.bufferpool[frame].IsPinned()
 }
 
-func (bm *BufferMgr) tryToPin(blk *file.BlockId) *Buffer {
-	buff := bm.findExistingBuffer(blk)
-	if buff == nil {
-		buff = bm.chooseUnpinnedBuffer()
-		if buff == nil {
-			return nil
+// wakeNext は空きバッファがあれば、先頭で待っている Pin を起こす。
+func (bm *BufferMgr) wakeNext() {
+	if len(bm.waiters) == 0 || bm.numAvailable == 0 {
+		return
+	}
+	select {
+	case bm.waiters[0].ready <- struct{}{}:
+	default: // すでに起こしてある
+	}
+}
+
+// removeWaiter は w を待ち行列から外し、残っている空きバッファを次の Pin に回す。
+func (bm *BufferMgr) removeWaiter(w *waiter) {
+	for i, w2 := range bm.waiters {
+		if w2 == w {
+			bm.waiters = append(bm.waiters[:i], bm.waiters[i+1:]...)
+			break
+		}
+	}
+	bm.wakeNext()
+}
+
+// tryToPin は blk をピンする。空きバッファがなければ nil を返す。
+// 置き換えるバッファが変更済みなら、bm.mu を外して書き出してから選び直す。
+func (bm *BufferMgr) tryToPin(blk *file.BlockId) (*Buffer, error) {
+	frame := bm.findLoadedBuffer(blk)
+	loaded := frame < 0
+	for loaded {
+		frame = bm.chooseUnpinnedBuffer()
+		if frame < 0 {
+			return nil, nil
+		}
+		if victim := bm.bufferpool[frame]; victim.ModifyingTx() >= 0 {
+			if err := bm.flushVictim(victim); err != nil {
+				return nil, err
+			}
+			// ロックを外している間に blk が読み込まれたかもしれない
+			frame = bm.findLoadedBuffer(blk)
+			loaded = frame < 0
+			continue
 		}
-		buff.assignToBlock(blk)
+		if err := bm.assignToBlock(frame, blk); err != nil {
+			return nil, err
+		}
+		bm.stats.Misses++
+		break
+	}
+	if !loaded {
+		bm.stats.Hits++
 	}
 
+	buff := bm.bufferpool[frame]
+	if buff.prefetched {
+		buff.prefetched = false
+		bm.stats.PrefetchHits++
+	}
 	if !buff.IsPinned() {
 		bm.numAvailable--
 	}
 
 	buff.pin()
-	return buff
+	buff.lastAccess = time.Now()
+	bm.replacer.Pinned(frame, buff.Block(), loaded)
+	bm.trackScan(blk)
+	return buff, nil
 }
 
-func (bm *BufferMgr) findExistingBuffer(blk *file.BlockId) *Buffer {
-	for _, buff := range bm.bufferpool {
-		blk2 := buff.Block()
-		if blk2 != nil && blk2.Equals(blk) {
-			return buff
-		}
+// flushVictim は置き換える前の変更済みのバッファのページを写し、bm.mu を外して書き出す。
+// 書き出している間は書き出し中にして、置き換えの対象から外す。
+// 書いている間にピンされて変更されたら、バッファは変更済みのまま残る。
+func (bm *BufferMgr) flushVictim(buff *Buffer) error {
+	buff.mu.Lock()
+	c := buff.copyPage()
+	buff.mu.Unlock()
+
+	buff.writing = true
+	bm.mu.Unlock()
+	err := buff.writeCopy(c)
+	bm.mu.Lock()
+	buff.writing = false
+	bm.writeDone.Broadcast()
+	bm.wakeNext()
+	return err
+}
+
+// findLoadedBuffer は findExistingBuffer と同じだが、先読みが blk を読み込み中なら
+// 読み終わるまで待つ。読めなかった blk はページテーブルから消えているので -1 を返す。
+func (bm *BufferMgr) findLoadedBuffer(blk *file.BlockId) int {
+	frame := bm.findExistingBuffer(blk)
+	for frame >= 0 && bm.bufferpool[frame].loading {
+		bm.writeDone.Wait()
+		frame = bm.findExistingBuffer(blk)
 	}
-	return nil
+	return frame
 }
 
-func (bm *BufferMgr) chooseUnpinnedBuffer() *Buffer {
-	for _, buff := range bm.bufferpool {
-		if !buff.IsPinned() {
-			return buff
-		}
+func (bm *BufferMgr) findExistingBuffer(blk *file.BlockId) int {
+	frame, ok := bm.pageTable[*blk]
+	if !ok {
+		return -1
 	}
+	return frame
+}
+
+// assignToBlock はフレームのバッファを blk に割り当て直し、ページテーブルを更新する。
+func (bm *BufferMgr) assignToBlock(frame int, blk *file.BlockId) error {
+	buff := bm.bufferpool[frame]
+	old := buff.Block()
+	err := buff.assignToBlock(blk)
+	if old != nil && (buff.Block() == nil || !buff.Block().Equals(old)) {
+		delete(bm.pageTable, *old)
+		bm.stats.Evictions++
+	}
+	if err != nil {
+		return err
+	}
+	bm.pageTable[*blk] = frame
 	return nil
 }
+
+// reserveFrame はフレームのバッファを blk の読み込み中にして、ページテーブルに載せる。
+// ページはまだ読まないので、呼び出し側が bm.mu を外して読み、loadDone を呼ぶ。
+// バッファは変更されていないこと。
+func (bm *BufferMgr) reserveFrame(frame int, blk *file.BlockId) {
+	buff := bm.bufferpool[frame]
+	if old := buff.Block(); old != nil {
+		delete(bm.pageTable, *old)
+		bm.stats.Evictions++
+	}
+	buff.blk = blk
+	buff.pins = 0
+	buff.prefetched = false
+	buff.loading = true
+	bm.pageTable[*blk] = frame
+}
+
+// loadDone は reserveFrame した読み込みを終える。読めなければフレームを空にする。
+func (bm *BufferMgr) loadDone(frame int, err error) {
+	buff := bm.bufferpool[frame]
+	buff.loading = false
+	if err != nil {
+		delete(bm.pageTable, *buff.blk)
+		buff.blk = nil
+	}
+	bm.writeDone.Broadcast()
+}
+
+func (bm *BufferMgr) chooseUnpinnedBuffer() int {
+	return bm.replacer.Victim(func(frame int) bool {
+		buff := bm.bufferpool[frame]
+		return buff.IsPinned() || buff.writing || buff.loading
+	})
+}
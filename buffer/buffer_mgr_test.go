@@ -1,8 +1,8 @@
 package buffer_test
 
 import (
-	"os"
-	"path/filepath"
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/nfphys/simpledb-go/buffer"
@@ -10,24 +10,28 @@ import (
 	"github.com/nfphys/simpledb-go/log"
 )
 
-func setup(blocksize int) *file.FileMgr {
-	dbDir := filepath.Join(os.TempDir(), "testdb")
-	os.RemoveAll(dbDir)
-	return file.NewFileMgr(dbDir, blocksize)
+func setup(t *testing.T, blocksize int) *file.FileMgr {
+	fm, err := file.NewFileMgrWithStore(file.NewMemStore(), blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return fm
 }
 
 func cleanup(fm *file.FileMgr) {
 	fm.Close()
-	os.RemoveAll(filepath.Join(os.TempDir(), "testdb"))
 }
 
 func TestPinOnce(t *testing.T) {
 	// Given
 	blocksize := 4096
-	fm := setup(blocksize)
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
-	lm := log.NewLogMgr(fm, "logfile")
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	bm := buffer.NewBufferMgr(fm, lm, 3)
 
 	blk := file.NewBlockId("testfile", 0)
@@ -53,10 +57,13 @@ func TestPinOnce(t *testing.T) {
 func TestPinTheSameBlockTwice(t *testing.T) {
 	// Given
 	blocksize := 4096
-	fm := setup(blocksize)
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
-	lm := log.NewLogMgr(fm, "logfile")
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	bm := buffer.NewBufferMgr(fm, lm, 3)
 
 	blk := file.NewBlockId("testfile", 0)
@@ -83,10 +90,13 @@ func TestPinTheSameBlockTwice(t *testing.T) {
 func TestPinDifferentBlocks(t *testing.T) {
 	// Given
 	blocksize := 4096
-	fm := setup(blocksize)
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
-	lm := log.NewLogMgr(fm, "logfile")
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	bm := buffer.NewBufferMgr(fm, lm, 3)
 
 	blk1 := file.NewBlockId("testfile", 0)
@@ -120,10 +130,13 @@ func TestPinDifferentBlocks(t *testing.T) {
 func TestCannotPinMoreThanAvailable(t *testing.T) {
 	// Given
 	blocksize := 4096
-	fm := setup(blocksize)
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
-	lm := log.NewLogMgr(fm, "logfile")
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	bm := buffer.NewBufferMgr(fm, lm, 1)
 
 	blk1 := file.NewBlockId("testfile", 0)
@@ -134,7 +147,7 @@ func TestCannotPinMoreThanAvailable(t *testing.T) {
 	buff2, err := bm.Pin(blk2)
 
 	// Then
-	if err != buffer.ErrBufferNotFound {
+	if !errors.Is(err, buffer.ErrBufferNotFound) {
 		t.Errorf("Expected buffer not found error, got %v", err)
 	}
 	if buff2 != nil {
@@ -145,10 +158,13 @@ func TestCannotPinMoreThanAvailable(t *testing.T) {
 func TestUnpin(t *testing.T) {
 	// Given
 	blocksize := 4096
-	fm := setup(blocksize)
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
-	lm := log.NewLogMgr(fm, "logfile")
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	bm := buffer.NewBufferMgr(fm, lm, 3)
 
 	blk := file.NewBlockId("testfile", 0)
@@ -165,3 +181,77 @@ func TestUnpin(t *testing.T) {
 		t.Errorf("Expected 3 buffers available, got %d", bm.Available())
 	}
 }
+
+func TestPinAfterEviction(t *testing.T) {
+	// Given
+	blocksize := 400
+	fm := setup(t, blocksize)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 2)
+
+	p := file.NewPage(blocksize)
+	for n := 0; n < 3; n++ {
+		p.SetInt(0, 100+n)
+		fm.Write(file.NewBlockId("testfile", n), p)
+	}
+
+	// When
+	for _, n := range []int{0, 1, 2, 0, 2, 1, 0} {
+		buff, err := bm.Pin(file.NewBlockId("testfile", n))
+
+		// Then
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !buff.Block().Equals(file.NewBlockId("testfile", n)) {
+			t.Errorf("Expected block %d, got %v", n, buff.Block())
+		}
+		if v, _ := buff.Contents().GetInt(0); v != 100+n  {
+			t.Errorf("Expected %d, got %d", 100+n, v)
+		}
+		bm.Unpin(buff)
+	}
+}
+
+// BenchmarkPinHit はプールにあるブロックのピンにかかる時間がプールの大きさによらないことを確かめる。
+func BenchmarkPinHit(b *testing.B) {
+	for _, numbuffs := range []int{16, 1024, 65536} {
+		b.Run(fmt.Sprintf("buffers=%d", numbuffs), func(b *testing.B) {
+			fm, err := file.NewFileMgrWithStore(file.NewMemStore(), 400)
+			if err != nil {
+				b.Fatalf("Expected no error, got %v", err)
+			}
+			defer cleanup(fm)
+
+			lm, err := log.NewLogMgr(fm, "logfile")
+			if err != nil {
+				b.Fatalf("Expected no error, got %v", err)
+			}
+			bm := buffer.NewBufferMgr(fm, lm, numbuffs, buffer.WithReplacement(buffer.Clock))
+
+			blks := make([]*file.BlockId, numbuffs)
+			for n := range blks {
+				blks[n] = file.NewBlockId("testfile", n)
+				buff, err := bm.Pin(blks[n])
+				if err != nil {
+					b.Fatalf("Expected no error, got %v", err)
+				}
+				bm.Unpin(buff)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buff, err := bm.Pin(blks[(i*7919)%numbuffs])
+				if err != nil {
+					b.Fatalf("Expected no error, got %v", err)
+				}
+				bm.Unpin(buff)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package buffer_test
+
+import (
+	"testing"
+
+	"github.com/nfphys/simpledb-go/buffer"
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/log"
+)
+
+// walStore はデータファイルへの書き込みのたびに、その時点でディスクにあるログの LSN を記録する。
+type walStore struct {
+	*file.MemStore
+	lm *log.LogMgr
+	flushedAtWrite []int
+}
+
+func (s *walStore) WriteAt(filename string, b []byte, off int64) (int, error) {
+	if filename == "testfile" {
+		s.flushedAtWrite = append(s.flushedAtWrite, s.lm.FlushedLSN())
+	}
+	return s.MemStore.WriteAt(filename, b, off)
+}
+
+func TestFlushWritesLogBeforePage(t *testing.T) {
+	// Given
+	blocksize := 400
+	store := &walStore{MemStore: file.NewMemStore()}
+	fm, err := file.NewFileMgrWithStore(store, blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	store.lm = lm
+	bm := buffer.NewBufferMgr(fm, lm, 1)
+
+	buff, err := bm.Pin(file.NewBlockId("testfile", 0))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	buff.Contents().SetInt(0, 42)
+	lsn, _ := lm.Append([]byte("set int record"))
+	buff.SetModified(1, lsn)
+	lm.Append([]byte("later record"))
+	bm.Unpin(buff)
+
+	// When
+	_, err = bm.Pin(file.NewBlockId("testfile", 1)) // 唯一のバッファを置き換えさせる
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(store.flushedAtWrite) != 1 {
+		t.Fatalf("Expected 1 page write, got %d", len(store.flushedAtWrite))
+	}
+	if store.flushedAtWrite[0] < lsn {
+		t.Errorf("Expected log flushed to LSN %d before page write, got %d", lsn, store.flushedAtWrite[0])
+	}
+	if buff.ModifyingTx() != -1 {
+		t.Errorf("Expected buffer to be clean after flush, got txnum %d", buff.ModifyingTx())
+	}
+}
+
+func TestFlushWithoutLogRecord(t *testing.T) {
+	// Given
+	blocksize := 400
+	fm := setup(t, blocksize)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 1)
+	buff, err := bm.Pin(file.NewBlockId("testfile", 0))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lm.Append([]byte("unrelated record"))
+
+	// When
+	buff.Contents().SetInt(0, 42)
+	buff.SetModified(1, -1) // ログを書かない変更 (undo など)
+	err = buff.Flush()
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if lm.FlushedLSN() != 0 {
+		t.Errorf("Expected log not to be flushed, got LSN %d", lm.FlushedLSN())
+	}
+	p := file.NewPage(blocksize)
+	fm.Read(file.NewBlockId("testfile", 0), p)
+	if v, _ := p.GetInt(0); v != 42  {
+		t.Errorf("Expected 42 on disk, got %d", v)
+	}
+}
@@ -0,0 +1,53 @@
+package buffer
+
+import (
+	"time"
+
+	"github.com/nfphys/simpledb-go/file"
+)
+
+type Option func(*BufferMgr)
+
+// WithReplacement は置き換えるバッファの選び方を policy にする。
+// 指定しなければ Naive を使う。
+func WithReplacement(policy Policy) Option {
+	return func(bm *BufferMgr) {
+		bm.replacer = policy(len(bm.bufferpool))
+	}
+}
+
+// WithMaxWait は期限のない Pin が空きバッファを待つ時間を d にする。
+// 0 以下なら空くまで待ち続ける。
+func WithMaxWait(d time.Duration) Option {
+	return func(bm *BufferMgr) {
+		bm.maxWait = d
+	}
+}
+
+// WithPageWriter はバックグラウンドの page writer を動かす。
+// interval ごとに、ピンされていない変更済みのバッファを最大 batch 個ディスクに書く。
+// interval か batch が 0 以下なら page writer は動かさない。
+// 止めるには BufferMgr.Close を呼ぶ。
+func WithPageWriter(interval time.Duration, batch int) Option {
+	return func(bm *BufferMgr) {
+		if interval <= 0 || batch <= 0 {
+			bm.pw = nil
+			return
+		}
+		bm.pw = &pageWriter{
+			interval: interval,
+			batch: batch,
+			page: file.NewPage(bm.fm.BlockSize()),
+			stop: make(chan struct{}),
+			done: make(chan struct{}),
+		}
+	}
+}
+
+// WithReadAhead は連続したブロックのピンを見つけたら、次の window 個のブロックを
+// 非同期に先読みする。0 なら先読みしない (既定)。
+func WithReadAhead(window int) Option {
+	return func(bm *BufferMgr) {
+		bm.readAhead = window
+	}
+}
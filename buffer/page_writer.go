@@ -0,0 +1,90 @@
+package buffer
+
+import (
+	"time"
+
+	"github.com/nfphys/simpledb-go/file"
+)
+
+// pageWriter はピンされていない変更済みのバッファを少しずつディスクに書く。
+// 置き換えやコミットのときにまとめて書く量を減らすためのもの。
+type pageWriter struct {
+	interval time.Duration
+	batch int
+	cursor int // 次に調べるフレーム
+	page *file.Page // 書き出し中のページの写し
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (bm *BufferMgr) runPageWriter() {
+	defer close(bm.pw.done)
+
+	ticker := time.NewTicker(bm.pw.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bm.pw.stop:
+			return
+		case <-ticker.C:
+			bm.writeDirtyPages(bm.pw.batch)
+		}
+	}
+}
+
+// writeDirtyPages はピンされていない変更済みのバッファを最大 n 個書き、書いた数を返す。
+// ページを写してからロックを外して書くので、書いている間も Pin は止まらない。
+// 書き出し中のバッファは置き換えの対象にしない。
+func (bm *BufferMgr) writeDirtyPages(n int) int {
+	written := 0
+	for written < n {
+		buff, blk, lsn, version := bm.nextDirtyPage()
+		if buff == nil {
+			break
+		}
+
+		err := buff.write(blk, bm.pw.page, lsn)
+		if err == nil && buff.version == version {
+			buff.txnum = -1 // 写した後に変更されていなければもう書かなくてよい
+		}
+		buff.mu.Unlock()
+
+		bm.mu.Lock()
+		buff.writing = false
+		bm.writeDone.Broadcast()
+		bm.wakeNext()
+		bm.mu.Unlock()
+
+		if err != nil {
+			break // 次の周期でやり直す
+		}
+		written++
+	}
+	return written
+}
+
+// nextDirtyPage は cursor から 1 周してピンされていない変更済みのバッファを探し、
+// ページを pw.page に写す。見つけたバッファは mu を取ったまま返す。
+func (bm *BufferMgr) nextDirtyPage() (*Buffer, *file.BlockId, int, int64) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	for range bm.bufferpool {
+		buff := bm.bufferpool[bm.pw.cursor]
+		bm.pw.cursor = (bm.pw.cursor + 1) % len(bm.bufferpool)
+
+		if buff.IsPinned() || buff.writing || buff.Block() == nil || !buff.mu.TryLock() {
+			continue
+		}
+		if buff.txnum < 0 {
+			buff.mu.Unlock()
+			continue
+		}
+
+		bm.pw.page.CopyFrom(buff.contents)
+		buff.writing = true
+		return buff, buff.Block(), buff.lsn, buff.version
+	}
+	return nil, nil, 0, 0
+}
@@ -0,0 +1,374 @@
+package buffer_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nfphys/simpledb-go/buffer"
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/log"
+)
+
+// modify はブロックをピンして値を書き、txnum の変更として記録する。
+func modify(t *testing.T, bm *buffer.BufferMgr, blk *file.BlockId, txnum int, val int) *buffer.Buffer {
+	buff, err := bm.Pin(blk)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	buff.Contents().SetInt(0, val)
+	buff.SetModified(txnum, -1)
+	return buff
+}
+
+func readInt(t *testing.T, fm *file.FileMgr, blk *file.BlockId) int {
+	p := file.NewPage(fm.BlockSize())
+	if err := fm.Read(blk, p); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	v, err := p.GetInt(0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return v
+}
+
+func TestPageWriter(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3, buffer.WithPageWriter(time.Millisecond, 10), buffer.WithReplacement(buffer.LRU))
+	defer bm.Close()
+
+	blk0 := file.NewBlockId("testfile", 0)
+	blk1 := file.NewBlockId("testfile", 1)
+	blk2 := file.NewBlockId("testfile", 2)
+
+	// When
+	bm.Unpin(modify(t, bm, blk0, 1, 100))
+	bm.Unpin(modify(t, bm, blk1, 1, 101))
+	modify(t, bm, blk2, 1, 102) // ピンしたまま
+
+	// Then
+	deadline := time.Now().Add(time.Second)
+	for bm.Stats().DirtyWrites < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if readInt(t, fm, blk0) != 100 || readInt(t, fm, blk1) != 101 {
+		t.Errorf("Expected unpinned pages on disk, got %d and %d", readInt(t, fm, blk0), readInt(t, fm, blk1))
+	}
+	if readInt(t, fm, blk2) != 0 {
+		t.Errorf("Expected pinned page not to be written, got %d", readInt(t, fm, blk2))
+	}
+	if bm.Stats().Evictions != 0 {
+		t.Errorf("Expected pages to be written without evictions, got %d", bm.Stats().Evictions)
+	}
+	frames := bm.Frames()
+	if frames[0].Dirty || frames[1].Dirty || !frames[2].Dirty {
+		t.Errorf("Expected only the pinned buffer to stay dirty, got %v", frames)
+	}
+}
+
+func TestPageWriterWithConcurrentUpdates(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 4, buffer.WithPageWriter(time.Microsecond, 4), buffer.WithMaxWait(0))
+	defer bm.Close()
+
+	// When
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= 200; i++ {
+				blk := file.NewBlockId("testfile", (g+i)%6)
+				buff, err := bm.Pin(blk)
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+					return
+				}
+				buff.Contents().SetInt(4*g, i) // ゴルーチンごとに別の場所を書く
+				buff.SetModified(g, -1)
+				bm.Unpin(buff)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := bm.FlushFile("testfile"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Then
+	for g := 0; g < 4; g++ {
+		for n := 0; n < 6; n++ {
+			p := file.NewPage(400)
+			fm.Read(file.NewBlockId("testfile", n), p)
+			// ブロック n を最後に書いたのは i ≡ n-g (mod 6) を満たす最大の i
+			expected := 200 - ((200 - (n - g)) % 6 + 6) % 6
+			if v, _ := p.GetInt(4*g); v != expected  {
+				t.Errorf("Expected %d at block %d offset %d, got %d", expected, n, 4*g, v)
+			}
+		}
+	}
+}
+
+func TestFlushAllByTransaction(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+
+	blk0 := file.NewBlockId("testfile", 0)
+	blk1 := file.NewBlockId("testfile", 1)
+	bm.Unpin(modify(t, bm, blk0, 1, 100))
+	modify(t, bm, blk1, 2, 101)
+
+	// When
+	err = bm.FlushAll(1)
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if readInt(t, fm, blk0) != 100 {
+		t.Errorf("Expected tx 1 page on disk, got %d", readInt(t, fm, blk0))
+	}
+	if readInt(t, fm, blk1) != 0 {
+		t.Errorf("Expected tx 2 page not to be written, got %d", readInt(t, fm, blk1))
+	}
+}
+
+func TestConcurrentFlushAllShareGroupCommit(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	// 8 個の Flush がそろうまで最大 1 秒待つ。FlushAll どうしが待ち合うと 8 秒かかる
+	lm, err := log.NewLogMgr(fm, "logfile", log.WithGroupCommit(time.Second, 8))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer lm.Close()
+	bm := buffer.NewBufferMgr(fm, lm, 8)
+
+	// When
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buff, err := bm.Pin(file.NewBlockId("testfile", i))
+			if err != nil {
+				errs <- err
+				return
+			}
+			lsn, err := lm.Append([]byte("update"))
+			if err != nil {
+				errs <- err
+				return
+			}
+			buff.Contents().SetInt(0, i)
+			buff.SetModified(i, lsn)
+			errs <- bm.FlushAll(i)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// Then
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected FlushAll calls to share one log flush, took %v", elapsed)
+	}
+	for i := range 8 {
+		if v := readInt(t, fm, file.NewBlockId("testfile", i)); v != i {
+			t.Errorf("Expected %d on disk, got %d", i, v)
+		}
+	}
+}
+
+func TestFlushFile(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+
+	blkA := file.NewBlockId("fileA", 0)
+	blkB := file.NewBlockId("fileB", 0)
+	modify(t, bm, blkA, 1, 100)
+	modify(t, bm, blkB, 1, 101)
+
+	// When
+	err = bm.FlushFile("fileA")
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if readInt(t, fm, blkA) != 100 {
+		t.Errorf("Expected fileA page on disk, got %d", readInt(t, fm, blkA))
+	}
+	if readInt(t, fm, blkB) != 0 {
+		t.Errorf("Expected fileB page not to be written, got %d", readInt(t, fm, blkB))
+	}
+}
+
+func TestEvictionFlushDoesNotBlockHits(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile", log.WithGroupCommit(300*time.Millisecond, 100))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer lm.Close()
+	bm := buffer.NewBufferMgr(fm, lm, 2, buffer.WithReplacement(buffer.LRU))
+
+	blk0 := file.NewBlockId("testfile", 0)
+	blk1 := file.NewBlockId("testfile", 1)
+	buff, _ := bm.Pin(blk0)
+	lsn, _ := lm.Append([]byte("update"))
+	buff.SetModified(1, lsn)
+	bm.Unpin(buff)
+	bm.Pin(blk1)
+
+	// blk0 を置き換えるために、group commit を待ってログを書き出す
+	evicted := make(chan error, 1)
+	go func() {
+		_, err := bm.Pin(file.NewBlockId("testfile", 2))
+		evicted <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// When
+	start := time.Now()
+	_, err = bm.Pin(blk1)
+	elapsed := time.Since(start)
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if elapsed > 100*time.Millisecond {
+		t.Errorf("Expected hit not to wait for the eviction flush, took %v", elapsed)
+	}
+	if err := <-evicted; err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestPageWriterDisabledByNonPositiveArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		interval time.Duration
+		batch int
+	}{
+		{"zero interval", 0, 10},
+		{"negative interval", -time.Millisecond, 10},
+		{"zero batch", time.Millisecond, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			fm := setup(t, 400)
+			defer cleanup(fm)
+
+			lm, err := log.NewLogMgr(fm, "logfile")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			// When
+			bm := buffer.NewBufferMgr(fm, lm, 3, buffer.WithPageWriter(tt.interval, tt.batch))
+			blk := file.NewBlockId("testfile", 0)
+			bm.Unpin(modify(t, bm, blk, 1, 100))
+			time.Sleep(10 * time.Millisecond)
+
+			// Then
+			if readInt(t, fm, blk) != 0 {
+				t.Errorf("Expected page not to be written, got %d", readInt(t, fm, blk))
+			}
+			bm.Close()
+		})
+	}
+}
+
+func TestModifyDuringEvictionFlush(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile", log.WithGroupCommit(100*time.Millisecond, 100))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer lm.Close()
+	bm := buffer.NewBufferMgr(fm, lm, 2, buffer.WithReplacement(buffer.LRU))
+
+	blk0 := file.NewBlockId("testfile", 0)
+	buff, _ := bm.Pin(blk0)
+	lsn, _ := lm.Append([]byte("update1"))
+	buff.Contents().SetInt(0, 100)
+	buff.SetModified(1, lsn)
+	bm.Unpin(buff)
+	bm.Pin(file.NewBlockId("testfile", 1))
+
+	// blk0 を置き換えるために、group commit を待ってから書き出す
+	evicted := make(chan error, 1)
+	go func() {
+		_, err := bm.Pin(file.NewBlockId("testfile", 2))
+		evicted <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// When
+	buff, err = bm.Pin(blk0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lsn, _ = lm.Append([]byte("update2"))
+	buff.Contents().SetInt(0, 200)
+	buff.SetModified(2, lsn)
+	bm.Unpin(buff)
+
+	// Then
+	if err := <-evicted; err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if v := readInt(t, fm, blk0); v != 200 {
+		t.Errorf("Expected the later change on disk, got %d", v)
+	}
+	if flushed := lm.FlushedLSN(); flushed < lsn {
+		t.Errorf("Expected log flushed through %d before the page, got %d", lsn, flushed)
+	}
+}
@@ -0,0 +1,182 @@
+package buffer_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nfphys/simpledb-go/buffer"
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/log"
+)
+
+// setupFullPool はバッファを 1 つだけ持ち、それがピンされている BufferMgr を作る。
+func setupFullPool(t *testing.T, opts ...buffer.Option) (*buffer.BufferMgr, *buffer.Buffer) {
+	fm := setup(t, 400)
+	t.Cleanup(func() { cleanup(fm) })
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 1, opts...)
+
+	buff, err := bm.Pin(file.NewBlockId("testfile", 0))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return bm, buff
+}
+
+// waitForWaiters は n 個の Pin が待ち始めるまで待つ。
+func waitForWaiters(t *testing.T, bm *buffer.BufferMgr, n int) {
+	deadline := time.Now().Add(time.Second)
+	for bm.Waiters() < n {
+		if time.Now().After(deadline) {
+			t.Errorf("Expected %d waiters, got %d", n, bm.Waiters())
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPinContextCanceled(t *testing.T) {
+	// Given
+	bm, _ := setupFullPool(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		waitForWaiters(t, bm, 1)
+		cancel()
+	}()
+
+	// When
+	buff, err := bm.PinContext(ctx, file.NewBlockId("testfile", 1))
+
+	// Then
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, buffer.ErrBufferNotFound) {
+		t.Errorf("Expected canceled buffer not found error, got %v", err)
+	}
+	if buff != nil {
+		t.Errorf("Expected nil buffer, got %v", buff)
+	}
+	if bm.Waiters() != 0 {
+		t.Errorf("Expected no waiters, got %d", bm.Waiters())
+	}
+}
+
+func TestPinContextDeadline(t *testing.T) {
+	// Given
+	bm, _ := setupFullPool(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// When
+	start := time.Now()
+	_, err := bm.PinContext(ctx, file.NewBlockId("testfile", 1))
+
+	// Then
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > buffer.MAX_TIME*time.Millisecond/2 {
+		t.Errorf("Expected pin to give up at the context deadline, took %v", elapsed)
+	}
+}
+
+func TestWithMaxWait(t *testing.T) {
+	// Given
+	bm, _ := setupFullPool(t, buffer.WithMaxWait(20*time.Millisecond))
+
+	// When
+	start := time.Now()
+	blk := file.NewBlockId("testfile", 1)
+	_, err := bm.Pin(blk)
+
+	// Then
+	if !errors.Is(err, buffer.ErrBufferNotFound) {
+		t.Errorf("Expected buffer not found error, got %v", err)
+	}
+	if expected := fmt.Sprintf("buffer: pin %v: %v", blk, buffer.ErrBufferNotFound); err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+	if elapsed := time.Since(start); elapsed > buffer.MAX_TIME*time.Millisecond/2 {
+		t.Errorf("Expected pin to give up after max wait, took %v", elapsed)
+	}
+}
+
+func TestPinWaitsForUnpin(t *testing.T) {
+	// Given
+	bm, held := setupFullPool(t, buffer.WithMaxWait(0))
+	go func() {
+		waitForWaiters(t, bm, 1)
+		bm.Unpin(held)
+	}()
+
+	// When
+	buff, err := bm.Pin(file.NewBlockId("testfile", 1))
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !buff.Block().Equals(file.NewBlockId("testfile", 1)) {
+		t.Errorf("Expected block 1, got %v", buff.Block())
+	}
+}
+
+func TestPinWaitersAreServedInOrder(t *testing.T) {
+	// Given
+	bm, held := setupFullPool(t, buffer.WithMaxWait(0))
+
+	var mu sync.Mutex
+	order := []int{}
+	var wg sync.WaitGroup
+	for i := 1; i <= 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buff, err := bm.Pin(file.NewBlockId("testfile", i))
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			bm.Unpin(buff)
+		}()
+		waitForWaiters(t, bm, i)
+	}
+
+	// When
+	bm.Unpin(held)
+	wg.Wait()
+
+	// Then
+	for i, n := range order {
+		if n != i+1 {
+			t.Fatalf("Expected waiters served in order [1 2 3 4 5], got %v", order)
+		}
+	}
+}
+
+func TestPinTimeoutDoesNotLeakGoroutines(t *testing.T) {
+	// Given
+	bm, _ := setupFullPool(t, buffer.WithMaxWait(time.Millisecond))
+	before := runtime.NumGoroutine()
+
+	// When
+	for n := 1; n <= 50; n++ {
+		bm.Pin(file.NewBlockId("testfile", n))
+	}
+
+	// Then
+	time.Sleep(10 * time.Millisecond)
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected no leaked goroutines, had %d before and %d after", before, after)
+	}
+}
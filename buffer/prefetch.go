@@ -0,0 +1,132 @@
+package buffer
+
+import (
+	"github.com/nfphys/simpledb-go/file"
+)
+
+// scanState はファイルごとの直近の Pin の並び。連続したブロックを読んでいれば先読みする。
+type scanState struct {
+	last int // 最後にピンしたブロック番号
+	run int // last で終わる連続したブロックの数
+	ahead int // 先読みを頼んだ最後のブロック番号
+	inflight bool // 先読みが動いている
+}
+
+// Prefetch は filename のブロック from から count 個を、まだプールになければ
+// ピンされていないバッファに非同期で読み込む。ファイルの末尾より先は読まない。
+// 待っている Pin がいるときや空きバッファがないときは読まずに諦める。
+func (bm *BufferMgr) Prefetch(filename string, from int, count int) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	bm.startPrefetch(filename, from, count, nil)
+}
+
+// startPrefetch は先読みの goroutine を起動する。bm.mu を取った状態で呼ぶ。
+// scan が nil でなければ、終わったときに inflight を下ろす。
+func (bm *BufferMgr) startPrefetch(filename string, from int, count int, scan *scanState) {
+	if bm.closed || count <= 0 {
+		return
+	}
+	if scan != nil {
+		scan.inflight = true
+	}
+
+	bm.prefetching.Add(1)
+	go func() {
+		defer bm.prefetching.Done()
+
+		length, err := bm.fm.Length(filename)
+
+		bm.mu.Lock()
+		defer bm.mu.Unlock()
+
+		if scan != nil {
+			defer func() { scan.inflight = false }()
+		}
+		if err != nil {
+			return
+		}
+		// 読み込んだばかりのブロックを同じ先読みで追い出さないよう、空いている数までにする
+		count = min(count, length-from, bm.numAvailable)
+		for n := from; n < from+count; n++ {
+			if !bm.prefetchBlock(file.NewBlockId(filename, n)) {
+				return
+			}
+		}
+	}()
+}
+
+// prefetchBlock は blk をピンせずにバッファに読み込む。bm.mu を取った状態で呼ぶ。
+// フレームとページテーブルを押さえてから bm.mu を外して読むので、読んでいる間も
+// 他の Pin は止まらない。読み込み中の blk をピンしようとした Pin は読み終わるまで待つ。
+func (bm *BufferMgr) prefetchBlock(blk *file.BlockId) bool {
+	for {
+		if bm.findExistingBuffer(blk) >= 0 {
+			return true
+		}
+		if len(bm.waiters) > 0 {
+			return false // 待っている Pin を優先する
+		}
+
+		frame := bm.chooseUnpinnedBuffer()
+		if frame < 0 {
+			return false
+		}
+		buff := bm.bufferpool[frame]
+		if buff.ModifyingTx() >= 0 {
+			if err := bm.flushVictim(buff); err != nil {
+				return false
+			}
+			continue // ロックを外している間に状況が変わったかもしれない
+		}
+
+		bm.reserveFrame(frame, blk)
+		bm.mu.Unlock()
+		err := bm.fm.Read(blk, buff.contents)
+		bm.mu.Lock()
+		bm.loadDone(frame, err)
+		if err != nil {
+			return false // 本当に必要なら Pin のときにエラーになる
+		}
+
+		buff.prefetched = true
+		bm.replacer.Pinned(frame, buff.Block(), true)
+		bm.stats.Prefetches++
+		return true
+	}
+}
+
+// trackScan は blk のピンを記録し、連続したブロックを読んでいれば
+// 先読みの窓の分だけ先のブロックを読み込ませる。bm.mu を取った状態で呼ぶ。
+func (bm *BufferMgr) trackScan(blk *file.BlockId) {
+	if bm.readAhead <= 0 {
+		return
+	}
+
+	scan, ok := bm.scans[blk.FileName()]
+	if !ok {
+		scan = &scanState{last: -1, ahead: -1}
+		bm.scans[blk.FileName()] = scan
+	}
+
+	n := blk.Number()
+	if n == scan.last {
+		return // 同じブロックを続けてピンしただけ
+	}
+	if n == scan.last+1 {
+		scan.run++
+	} else {
+		scan.run = 1
+		scan.ahead = n
+	}
+	scan.last = n
+
+	// 2 ブロック続けて読んだら順に読み進めているとみなし、窓の半分を過ぎたら次を頼む
+	if scan.run < 2 || scan.inflight || scan.ahead-n > bm.readAhead/2 {
+		return
+	}
+	from := max(scan.ahead+1, n+1)
+	scan.ahead = n + bm.readAhead
+	bm.startPrefetch(blk.FileName(), from, scan.ahead-from+1, scan)
+}
@@ -0,0 +1,169 @@
+package buffer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nfphys/simpledb-go/buffer"
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/log"
+)
+
+// setupFile は blocks 個のブロックを持つ testfile を作る。
+func setupFile(t *testing.T, blocks int, opts ...buffer.Option) (*file.FileMgr, *buffer.BufferMgr) {
+	fm := setup(t, 400)
+	t.Cleanup(func() { cleanup(fm) })
+
+	p := file.NewPage(400)
+	for n := 0; n < blocks; n++ {
+		p.SetInt(0, 100+n)
+		if err := fm.Write(file.NewBlockId("testfile", n), p); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return fm, buffer.NewBufferMgr(fm, lm, 8, opts...)
+}
+
+func pinAndUnpin(t *testing.T, bm *buffer.BufferMgr, n int) {
+	buff, err := bm.Pin(file.NewBlockId("testfile", n))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if v, _ := buff.Contents().GetInt(0); v != 100+n  {
+		t.Errorf("Expected %d in block %d, got %d", 100+n, n, v)
+	}
+	bm.Unpin(buff)
+}
+
+func TestPrefetch(t *testing.T) {
+	// Given
+	_, bm := setupFile(t, 5, buffer.WithReplacement(buffer.LRU))
+
+	// When
+	bm.Prefetch("testfile", 1, 3)
+	bm.Close() // 先読みが終わるのを待つ
+	for n := 0; n < 5; n++ {
+		pinAndUnpin(t, bm, n)
+	}
+
+	// Then
+	stats := bm.Stats()
+	if stats.Prefetches != 3 || stats.PrefetchHits != 3 {
+		t.Errorf("Expected 3 prefetches all used, got %+v", stats)
+	}
+	if stats.Hits != 3 || stats.Misses != 2 {
+		t.Errorf("Expected 3 hits and 2 misses, got %+v", stats)
+	}
+}
+
+func TestPrefetchStopsAtEndOfFile(t *testing.T) {
+	// Given
+	_, bm := setupFile(t, 3)
+
+	// When
+	bm.Prefetch("testfile", 1, 10)
+	bm.Close()
+
+	// Then
+	if stats := bm.Stats(); stats.Prefetches != 2 {
+		t.Errorf("Expected 2 prefetches, got %d", stats.Prefetches)
+	}
+}
+
+func TestSequentialReadAhead(t *testing.T) {
+	// Given
+	_, bm := setupFile(t, 20, buffer.WithReplacement(buffer.LRU), buffer.WithReadAhead(4))
+	defer bm.Close()
+
+	// When
+	pinAndUnpin(t, bm, 0)
+	pinAndUnpin(t, bm, 1) // ここで連続した読み込みとみなされる
+	deadline := time.Now().Add(time.Second)
+	for bm.Stats().Prefetches < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	for n := 2; n < 6; n++ {
+		pinAndUnpin(t, bm, n)
+	}
+
+	// Then
+	stats := bm.Stats()
+	if stats.Misses != 2 {
+		t.Errorf("Expected only the first 2 pins to miss, got %+v", stats)
+	}
+	if stats.PrefetchHits != 4 {
+		t.Errorf("Expected 4 prefetch hits, got %+v", stats)
+	}
+}
+
+func TestRandomAccessDoesNotReadAhead(t *testing.T) {
+	// Given
+	_, bm := setupFile(t, 20, buffer.WithReadAhead(4))
+
+	// When
+	for _, n := range []int{5, 2, 9, 1, 1, 12} {
+		pinAndUnpin(t, bm, n)
+	}
+	bm.Close()
+
+	// Then
+	if stats := bm.Stats(); stats.Prefetches != 0 {
+		t.Errorf("Expected no prefetches, got %d", stats.Prefetches)
+	}
+}
+
+// slowStore は testfile のブロック 2 以降の読み込みを遅らせる。
+type slowStore struct {
+	*file.MemStore
+	delay time.Duration
+}
+
+func (s *slowStore) ReadAt(filename string, b []byte, off int64) (int, error) {
+	if filename == "testfile" && off >= 2*400 {
+		time.Sleep(s.delay)
+	}
+	return s.MemStore.ReadAt(filename, b, off)
+}
+
+func TestReadAheadDoesNotBlockHits(t *testing.T) {
+	// Given
+	fm, err := file.NewFileMgrWithStore(&slowStore{MemStore: file.NewMemStore(), delay: 100 * time.Millisecond}, 400)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+	p := file.NewPage(400)
+	for n := 0; n < 8; n++ {
+		p.SetInt(0, 100+n)
+		fm.Write(file.NewBlockId("testfile", n), p)
+	}
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 8, buffer.WithReplacement(buffer.LRU), buffer.WithReadAhead(4))
+	defer bm.Close()
+
+	pinAndUnpin(t, bm, 0)
+	pinAndUnpin(t, bm, 1) // ブロック 2 から先読みが始まる
+	time.Sleep(20 * time.Millisecond)
+
+	// When
+	start := time.Now()
+	pinAndUnpin(t, bm, 0)
+	elapsed := time.Since(start)
+
+	// Then
+	if elapsed > 50*time.Millisecond {
+		t.Errorf("Expected hit not to wait for read-ahead, took %v", elapsed)
+	}
+	pinAndUnpin(t, bm, 2) // 読み込み中のブロックは読み終わるのを待ってピンする
+	if stats := bm.Stats(); stats.PrefetchHits != 1 {
+		t.Errorf("Expected in-flight block to be a prefetch hit, got %+v", stats)
+	}
+}
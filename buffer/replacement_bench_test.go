@@ -0,0 +1,150 @@
+package buffer_test
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/nfphys/simpledb-go/buffer"
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/log"
+)
+
+const traceBuffers = 32
+
+var policies = []struct {
+	name string
+	policy buffer.Policy
+}{
+	{"naive", buffer.Naive},
+	{"fifo", buffer.FIFO},
+	{"lru", buffer.LRU},
+	{"clock", buffer.Clock},
+	{"lru-2", buffer.LRUK(2)},
+}
+
+// traces はブロック番号の列。どれも同じ乱数の種から作るので毎回同じになる。
+var traces = []struct {
+	name string
+	blocks func() []int
+}{
+	// プールより 1 ブロックだけ大きい範囲を繰り返し読む
+	{"loop", func() []int {
+		var trace []int
+		for range 100 {
+			for n := 0; n <= traceBuffers; n++ {
+				trace = append(trace, n)
+			}
+		}
+		return trace
+	}},
+	// よく使うブロックを読む合間に、一度しか読まれないブロックをスキャンする
+	{"hot+scan", func() []int {
+		r := rand.New(rand.NewSource(1))
+		var trace []int
+		next := 1000
+		for range 100 {
+			for range 100 {
+				trace = append(trace, r.Intn(traceBuffers/2))
+			}
+			for range traceBuffers {
+				trace = append(trace, next)
+				next++
+			}
+		}
+		return trace
+	}},
+	// アクセスの偏りが Zipf 分布に従う
+	{"zipf", func() []int {
+		r := rand.New(rand.NewSource(1))
+		z := rand.NewZipf(r, 1.1, 1, 1000)
+		var trace []int
+		for range 10000 {
+			trace = append(trace, int(z.Uint64()))
+		}
+		return trace
+	}},
+}
+
+// readCountingStore はデータファイルからの読み込み (= バッファのミス) を数える。
+type readCountingStore struct {
+	*file.MemStore
+	mu sync.Mutex
+	reads int
+}
+
+func (s *readCountingStore) ReadAt(filename string, b []byte, off int64) (int, error) {
+	if filename == "tracefile" {
+		s.mu.Lock()
+		s.reads++
+		s.mu.Unlock()
+	}
+	return s.MemStore.ReadAt(filename, b, off)
+}
+
+// replay は trace を 1 ブロックずつピンしてすぐに外し、ヒット率を返す。
+func replay(tb testing.TB, policy buffer.Policy, trace []int) float64 {
+	store := &readCountingStore{MemStore: file.NewMemStore()}
+	fm, err := file.NewFileMgrWithStore(store, 400)
+	if err != nil {
+		tb.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		tb.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, traceBuffers, buffer.WithReplacement(policy))
+
+	for _, n := range trace {
+		buff, err := bm.Pin(file.NewBlockId("tracefile", n))
+		if err != nil {
+			tb.Fatalf("Expected no error, got %v", err)
+		}
+		bm.Unpin(buff)
+	}
+
+	return 1 - float64(store.reads)/float64(len(trace))
+}
+
+// BenchmarkReplacement はトレースごとに各ポリシーのヒット率を hit% として報告する。
+//
+//	go test ./buffer -run '^$' -bench Replacement
+func BenchmarkReplacement(b *testing.B) {
+	for _, tr := range traces {
+		trace := tr.blocks()
+		for _, p := range policies {
+			b.Run(tr.name+"/"+p.name, func(b *testing.B) {
+				var ratio float64
+				for i := 0; i < b.N; i++ {
+					ratio = replay(b, p.policy, trace)
+				}
+				b.ReportMetric(100*ratio, "hit%")
+			})
+		}
+	}
+}
+
+func TestReplacementHitRatios(t *testing.T) {
+	ratios := map[string]map[string]float64{}
+	for _, tr := range traces {
+		trace := tr.blocks()
+		ratios[tr.name] = map[string]float64{}
+		for _, p := range policies {
+			ratios[tr.name][p.name] = replay(t, p.policy, trace)
+		}
+		t.Logf("%s: %v", tr.name, ratios[tr.name])
+	}
+
+	// スキャンが混ざっても LRU-K はよく使うブロックを残す
+	if ratios["hot+scan"]["lru-2"] <= ratios["hot+scan"]["lru"] {
+		t.Errorf("Expected lru-2 to beat lru on hot+scan, got %v", ratios["hot+scan"])
+	}
+	// 偏りのあるアクセスでは、最近使ったブロックを残すポリシーが先頭から選ぶより良い
+	for _, name := range []string{"lru", "clock", "lru-2"} {
+		if ratios["zipf"][name] <= ratios["zipf"]["naive"] {
+			t.Errorf("Expected %s to beat naive on zipf, got %v", name, ratios["zipf"])
+		}
+	}
+}
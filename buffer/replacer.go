@@ -0,0 +1,202 @@
+package buffer
+
+import (
+	"github.com/nfphys/simpledb-go/file"
+)
+
+// Replacer はピンされていないバッファのうち、新しいブロックに割り当て直すものを選ぶ。
+// バッファはバッファプール内の位置 (フレーム番号) で指定する。
+// BufferMgr のロックを取った状態で呼ばれるので、実装はロックを取らなくてよい。
+type Replacer interface {
+	// Pinned はフレームのバッファがピンされるたびに呼ばれる。
+	// loaded はブロックを読み込んだばかり (ミス) かどうか。
+	Pinned(frame int, blk *file.BlockId, loaded bool)
+	// Victim は pinned が false を返すフレームから 1 つ選ぶ。なければ -1 を返す。
+	Victim(pinned func(frame int) bool) int
+}
+
+// Policy はバッファ数に合わせた Replacer を作る。WithReplacement に渡す。
+type Policy func(numbuffs int) Replacer
+
+// Naive はプールの先頭から最初に見つかったピンされていないバッファを選ぶ。
+func Naive(numbuffs int) Replacer {
+	return &naiveReplacer{numbuffs: numbuffs}
+}
+
+// FIFO は最も前にブロックを読み込んだバッファを選ぶ。
+func FIFO(numbuffs int) Replacer {
+	return &fifoReplacer{loadedAt: make([]int64, numbuffs)}
+}
+
+// LRU は最後にピンされたのが最も前のバッファを選ぶ。
+func LRU(numbuffs int) Replacer {
+	return &lruReplacer{pinnedAt: make([]int64, numbuffs)}
+}
+
+// Clock は参照ビットを使った second chance で選ぶ。
+func Clock(numbuffs int) Replacer {
+	return &clockReplacer{referenced: make([]bool, numbuffs)}
+}
+
+// LRUK は最近 k 回目のアクセスが最も前のブロックを選ぶ。
+// アクセスが k 回に満たないブロックを優先し、その中では LRU で選ぶので、
+// 一度しか読まれないスキャンのブロックがよく使うブロックを追い出しにくい。
+// k が 1 より小さければ 1 として扱うので、LRU と同じ選び方になる。
+func LRUK(k int) Policy {
+	k = max(k, 1)
+	return func(numbuffs int) Replacer {
+		return &lrukReplacer{
+			k: k,
+			retain: int64(4 * numbuffs),
+			blocks: make([]*file.BlockId, numbuffs),
+			history: make(map[file.BlockId][]int64),
+		}
+	}
+}
+
+type naiveReplacer struct {
+	numbuffs int
+}
+
+func (r *naiveReplacer) Pinned(frame int, blk *file.BlockId, loaded bool) {}
+
+func (r *naiveReplacer) Victim(pinned func(frame int) bool) int {
+	for i := 0; i < r.numbuffs; i++ {
+		if !pinned(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+type fifoReplacer struct {
+	tick int64
+	loadedAt []int64 // 0 ならまだ一度も使われていない
+}
+
+func (r *fifoReplacer) Pinned(frame int, blk *file.BlockId, loaded bool) {
+	if loaded {
+		r.tick++
+		r.loadedAt[frame] = r.tick
+	}
+}
+
+func (r *fifoReplacer) Victim(pinned func(frame int) bool) int {
+	return oldest(r.loadedAt, pinned)
+}
+
+type lruReplacer struct {
+	tick int64
+	pinnedAt []int64
+}
+
+func (r *lruReplacer) Pinned(frame int, blk *file.BlockId, loaded bool) {
+	r.tick++
+	r.pinnedAt[frame] = r.tick
+}
+
+func (r *lruReplacer) Victim(pinned func(frame int) bool) int {
+	return oldest(r.pinnedAt, pinned)
+}
+
+// oldest は pinned でないフレームのうち times が最も小さいものを返す。
+func oldest(times []int64, pinned func(frame int) bool) int {
+	victim := -1
+	for i, t := range times {
+		if pinned(i) {
+			continue
+		}
+		if victim < 0 || t < times[victim] {
+			victim = i
+		}
+	}
+	return victim
+}
+
+type clockReplacer struct {
+	hand int
+	referenced []bool
+}
+
+func (r *clockReplacer) Pinned(frame int, blk *file.BlockId, loaded bool) {
+	r.referenced[frame] = true
+}
+
+func (r *clockReplacer) Victim(pinned func(frame int) bool) int {
+	// 1 周目で参照ビットをすべて落とすので、2 周すれば必ず見つかる
+	for range 2 * len(r.referenced) {
+		i := r.hand
+		r.hand = (r.hand + 1) % len(r.referenced)
+		if pinned(i) {
+			continue
+		}
+		if r.referenced[i] {
+			r.referenced[i] = false
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+type lrukReplacer struct {
+	k int
+	tick int64
+	retain int64 // プールにないブロックの履歴を残しておくアクセス回数
+	blocks []*file.BlockId // フレームに載っているブロック
+	history map[file.BlockId][]int64 // ブロックごとの最近 k 回のアクセス時刻。新しいものから順
+}
+
+func (r *lrukReplacer) Pinned(frame int, blk *file.BlockId, loaded bool) {
+	r.tick++
+	r.blocks[frame] = blk
+
+	h := r.history[*blk]
+	if len(h) < r.k {
+		h = append(h, 0)
+	}
+	copy(h[1:], h)
+	h[0] = r.tick
+	r.history[*blk] = h
+
+	if len(r.history) > 2*int(r.retain) {
+		r.forget()
+	}
+}
+
+func (r *lrukReplacer) Victim(pinned func(frame int) bool) int {
+	victim := -1
+	var victimK, victimLast int64
+	for i, blk := range r.blocks {
+		if pinned(i) {
+			continue
+		}
+		var kth, last int64 // 使われていないフレームは 0 のまま最優先になる
+		if blk != nil {
+			h := r.history[*blk]
+			last = h[0]
+			if len(h) == r.k {
+				kth = h[r.k-1]
+			}
+		}
+		if victim < 0 || kth < victimK || (kth == victimK && last < victimLast) {
+			victim, victimK, victimLast = i, kth, last
+		}
+	}
+	return victim
+}
+
+// forget はプールになく、しばらくアクセスされていないブロックの履歴を捨てる。
+func (r *lrukReplacer) forget() {
+	resident := make(map[file.BlockId]bool, len(r.blocks))
+	for _, blk := range r.blocks {
+		if blk != nil {
+			resident[*blk] = true
+		}
+	}
+	for blk, h := range r.history {
+		if !resident[blk] && r.tick-h[0] > r.retain {
+			delete(r.history, blk)
+		}
+	}
+}
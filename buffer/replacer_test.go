@@ -0,0 +1,107 @@
+package buffer_test
+
+import (
+	"testing"
+
+	"github.com/nfphys/simpledb-go/buffer"
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/log"
+)
+
+func TestReplacementPolicies(t *testing.T) {
+	tests := []struct {
+		name string
+		policy buffer.Policy
+		trace []int // 順にピンしてすぐに外すブロック
+		expectedFrame int // 最後にブロック 99 を読み込むフレーム (最初にブロック 0, 1, 2 を読み込んだ順)
+	}{
+		{"naive", buffer.Naive, []int{0, 1, 2, 3}, 0},
+		{"fifo", buffer.FIFO, []int{0, 1, 2, 3}, 1},
+		{"lru", buffer.LRU, []int{0, 1, 2, 0}, 1},
+		{"lru reaccess", buffer.LRU, []int{0, 1, 2, 1, 0}, 2},
+		{"clock", buffer.Clock, []int{0, 1, 2, 3, 1}, 2},
+		{"lru-2", buffer.LRUK(2), []int{0, 0, 1, 1, 2}, 2},
+		{"lru-2 scan", buffer.LRUK(2), []int{0, 0, 1, 1, 2, 3}, 2}, // 1 回しか読まれないブロック 3 を追い出す
+		{"lru-0", buffer.LRUK(0), []int{0, 1, 2, 1, 0}, 2}, // k が 1 より小さければ LRU と同じ
+		{"lru-negative", buffer.LRUK(-1), []int{0, 1, 2, 1, 0}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			fm := setup(t, 400)
+			defer cleanup(fm)
+
+			lm, err := log.NewLogMgr(fm, "logfile")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			bm := buffer.NewBufferMgr(fm, lm, 3, buffer.WithReplacement(tt.policy))
+
+			frames := map[*buffer.Buffer]int{}
+			for _, n := range tt.trace {
+				buff, err := bm.Pin(file.NewBlockId("testfile", n))
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if _, ok := frames[buff]; !ok {
+					frames[buff] = len(frames)
+				}
+				bm.Unpin(buff)
+			}
+
+			// When
+			buff, err := bm.Pin(file.NewBlockId("testfile", 99))
+
+			// Then
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if frames[buff] != tt.expectedFrame {
+				t.Errorf("Expected frame %d to be replaced, got %d", tt.expectedFrame, frames[buff])
+			}
+		})
+	}
+}
+
+func TestReplacementSkipsPinnedBuffers(t *testing.T) {
+	policies := map[string]buffer.Policy{
+		"naive": buffer.Naive,
+		"fifo": buffer.FIFO,
+		"lru": buffer.LRU,
+		"clock": buffer.Clock,
+		"lru-2": buffer.LRUK(2),
+	}
+
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fm := setup(t, 400)
+			defer cleanup(fm)
+
+			lm, err := log.NewLogMgr(fm, "logfile")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			bm := buffer.NewBufferMgr(fm, lm, 2, buffer.WithReplacement(policy))
+
+			pinned, _ := bm.Pin(file.NewBlockId("testfile", 0))
+			buff, _ := bm.Pin(file.NewBlockId("testfile", 1))
+			bm.Unpin(buff)
+
+			// When
+			for n := 2; n < 5; n++ {
+				buff, err = bm.Pin(file.NewBlockId("testfile", n))
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				bm.Unpin(buff)
+			}
+
+			// Then
+			if !pinned.Block().Equals(file.NewBlockId("testfile", 0)) {
+				t.Errorf("Expected pinned buffer to keep block 0, got %v", pinned.Block())
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package buffer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nfphys/simpledb-go/file"
+)
+
+// Stats は BufferMgr を作ってからの累計。
+type Stats struct {
+	Hits int64 // すでにプールにあったブロックの Pin
+	Misses int64 // ディスクから読み込んだブロックの Pin
+	Evictions int64 // 別のブロックが載っていたバッファを割り当て直した回数
+	DirtyWrites int64 // ディスクに書いた変更済みページの数
+	PinWaits int64 // 空きバッファを待った Pin の数
+	WaitTimeouts int64 // 待ったが空かずに諦めた Pin の数
+	TotalWait time.Duration // Pin が空きバッファを待った時間の合計
+	Prefetches int64 // 先読みでディスクから読み込んだブロックの数
+	PrefetchHits int64 // 先読みしたブロックがピンされた数
+}
+
+// HitRatio は Pin のうちプールにあったブロックの割合を返す。
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// AvgWait は待った Pin 1 回あたりの待ち時間を返す。
+func (s Stats) AvgWait() time.Duration {
+	if s.PinWaits == 0 {
+		return 0
+	}
+	return s.TotalWait / time.Duration(s.PinWaits)
+}
+
+// FrameInfo はバッファ 1 つの状態。
+type FrameInfo struct {
+	Frame int
+	Block *file.BlockId // 何も載っていなければ nil
+	Pins int
+	Dirty bool
+	ModifyingTx int // Dirty でなければ -1
+	LastAccess time.Time // 最後にピンされた時刻。一度もなければゼロ値
+}
+
+func (f FrameInfo) String() string {
+	if f.Block == nil {
+		return fmt.Sprintf("frame %d: empty", f.Frame)
+	}
+	return fmt.Sprintf("frame %d: %v pins=%d dirty=%t tx=%d last=%s",
+		f.Frame, f.Block, f.Pins, f.Dirty, f.ModifyingTx, f.LastAccess.Format(time.RFC3339Nano))
+}
+
+// Stats は統計のスナップショットを返す。
+func (bm *BufferMgr) Stats() Stats {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	stats := bm.stats
+	for _, buff := range bm.bufferpool {
+		stats.DirtyWrites += buff.dirtyWrites.Load()
+	}
+	return stats
+}
+
+// Frames はバッファプールの各バッファの状態をフレーム順に返す。
+func (bm *BufferMgr) Frames() []FrameInfo {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	frames := make([]FrameInfo, len(bm.bufferpool))
+	for i, buff := range bm.bufferpool {
+		frames[i] = FrameInfo{
+			Frame: i,
+			Block: buff.Block(),
+			Pins: buff.pins,
+			Dirty: buff.ModifyingTx() >= 0,
+			ModifyingTx: buff.ModifyingTx(),
+			LastAccess: buff.lastAccess,
+		}
+	}
+	return frames
+}
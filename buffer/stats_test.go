@@ -0,0 +1,99 @@
+package buffer_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nfphys/simpledb-go/buffer"
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/log"
+)
+
+func TestStats(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 2, buffer.WithMaxWait(10*time.Millisecond))
+
+	// When
+	buff, _ := bm.Pin(file.NewBlockId("testfile", 0)) // ミス
+	bm.Pin(file.NewBlockId("testfile", 0)) // ヒット
+	bm.Unpin(buff)
+	bm.Unpin(buff)
+	buff, _ = bm.Pin(file.NewBlockId("testfile", 1)) // ブロック 0 を置き換える
+	buff.SetModified(1, -1)
+	bm.Unpin(buff)
+	bm.Pin(file.NewBlockId("testfile", 2)) // 変更されたブロック 1 を書いて置き換える
+	bm.Pin(file.NewBlockId("testfile", 3)) // 空いているバッファを使う
+	_, err = bm.Pin(file.NewBlockId("testfile", 4)) // 空かずに諦める
+
+	// Then
+	if !errors.Is(err, buffer.ErrBufferNotFound) {
+		t.Fatalf("Expected buffer not found error, got %v", err)
+	}
+	stats := bm.Stats()
+	expected := buffer.Stats{
+		Hits: 1,
+		Misses: 4,
+		Evictions: 2,
+		DirtyWrites: 1,
+		PinWaits: 1,
+		WaitTimeouts: 1,
+		TotalWait: stats.TotalWait,
+	}
+	if stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, stats)
+	}
+	if stats.AvgWait() < 10*time.Millisecond {
+		t.Errorf("Expected average wait of at least 10ms, got %v", stats.AvgWait())
+	}
+	if stats.HitRatio() != 0.2 {
+		t.Errorf("Expected hit ratio 0.2, got %v", stats.HitRatio())
+	}
+}
+
+func TestFrames(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+
+	// When
+	before := time.Now()
+	buff, _ := bm.Pin(file.NewBlockId("testfile", 0))
+	bm.Pin(file.NewBlockId("testfile", 0))
+	buff.SetModified(7, -1)
+	buff, _ = bm.Pin(file.NewBlockId("testfile", 1))
+	bm.Unpin(buff)
+	frames := bm.Frames()
+
+	// Then
+	if len(frames) != 3 {
+		t.Fatalf("Expected 3 frames, got %d", len(frames))
+	}
+	f := frames[0]
+	if !f.Block.Equals(file.NewBlockId("testfile", 0)) || f.Pins != 2 || !f.Dirty || f.ModifyingTx != 7 || f.LastAccess.Before(before) {
+		t.Errorf("Expected block 0 pinned twice and dirty by tx 7, got %v", f)
+	}
+	f = frames[1]
+	if !f.Block.Equals(file.NewBlockId("testfile", 1)) || f.Pins != 0 || f.Dirty || f.ModifyingTx != -1 {
+		t.Errorf("Expected clean unpinned block 1, got %v", f)
+	}
+	if frames[2].Block != nil || !frames[2].LastAccess.IsZero() {
+		t.Errorf("Expected empty frame, got %v", frames[2])
+	}
+	if s := frames[2].String(); s != "frame 2: empty" {
+		t.Errorf("Expected 'frame 2: empty', got '%s'", s)
+	}
+}
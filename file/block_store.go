@@ -0,0 +1,14 @@
+package file
+
+// BlockStore は FileMgr が読み書きするファイル群の置き場所。
+// 実装は複数の goroutine から同時に呼ばれてもよいようにする。
+type BlockStore interface {
+	ReadAt(filename string, b []byte, off int64) (int, error)
+	WriteAt(filename string, b []byte, off int64) (int, error)
+	Size(filename string) (int64, error)
+	Sync(filename string) error
+	Remove(filename string) error
+	Rename(oldname string, newname string) error
+	List() ([]string, error) // 格納されているファイル名を辞書順に返す
+	Close() error
+}
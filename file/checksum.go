@@ -0,0 +1,64 @@
+package file
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	PAGE_TRAILER_BYTES = 12 // layout: [lsn(uint64)][crc32c(uint32)]
+)
+
+var (
+	ErrCorruptBlock = errors.New("corrupt block")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptBlockError はトレイラのチェックサムが内容と一致しないブロックを表す。
+type CorruptBlockError struct {
+	Blk *BlockId
+	Stored uint32
+	Computed uint32
+}
+
+func (e *CorruptBlockError) Error() string {
+	return fmt.Sprintf("%v: %v: stored checksum %#08x, computed %#08x", ErrCorruptBlock, e.Blk, e.Stored, e.Computed)
+}
+
+func (e *CorruptBlockError) Unwrap() error {
+	return ErrCorruptBlock
+}
+
+// stampTrailer は contents と lsn を frame に詰め、末尾に CRC32C を書く。
+func stampTrailer(frame []byte, contents []byte, lsn int) {
+	n := copy(frame, contents)
+	binary.LittleEndian.PutUint64(frame[n:], uint64(lsn))
+	crc := crc32.Checksum(frame[:n+8], castagnoli)
+	binary.LittleEndian.PutUint32(frame[n+8:], crc)
+}
+
+// verifyTrailer は frame のチェックサムを検証し、内容を contents に戻して lsn を返す。
+// 一度も書かれていない (全て 0 の) ブロックは正しいものとして扱う。
+func verifyTrailer(blk *BlockId, frame []byte, contents []byte) (int, error) {
+	n := len(contents)
+	stored := binary.LittleEndian.Uint32(frame[n+8:])
+	computed := crc32.Checksum(frame[:n+8], castagnoli)
+	if stored != computed && !isZero(frame) {
+		return 0, &CorruptBlockError{Blk: blk, Stored: stored, Computed: computed}
+	}
+
+	copy(contents, frame[:n])
+	return int(binary.LittleEndian.Uint64(frame[n:])), nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
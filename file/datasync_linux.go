@@ -0,0 +1,13 @@
+package file
+
+import (
+	"syscall"
+)
+
+const (
+	oDSYNC = syscall.O_DSYNC
+)
+
+func (f *osFile) Datasync() error {
+	return syscall.Fdatasync(int(f.Fd()))
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package file
+
+import (
+	"os"
+)
+
+const (
+	oDSYNC = os.O_SYNC
+)
+
+// fdatasync がないプラットフォームでは fsync で代用する。
+func (f *osFile) Datasync() error {
+	return f.Sync()
+}
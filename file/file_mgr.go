@@ -1,104 +1,320 @@
 package file
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"os"
 	"sync"
 )
 
+var (
+	ErrShortRead = errors.New("short read")
+	ErrShortWrite = errors.New("short write")
+	ErrBlockOutOfRange = errors.New("block out of range")
+)
+
 type FileMgr struct {
-	dbDir string
+	store BlockStore
 	blocksize int
-	openFiles map[string]*os.File
+	header Header
+	checksums bool
+	frame []byte // チェックサム有効時のディスク上のブロック (ページ + トレイラ)
 	mu sync.Mutex
 }
 
-func NewFileMgr(dbDir string, blocksize int) *FileMgr {
-	err := os.MkdirAll(dbDir, 0777)
-	if err != nil && !os.IsExist(err) {
-		panic(err)
+// NewFileMgr は dbDir 以下の OS のファイルを使う FileMgr を作る。
+func NewFileMgr(dbDir string, blocksize int, opts ...Option) (*FileMgr, error) {
+	store, err := NewOSStore(dbDir, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFileMgrWithStore(store, blocksize, opts...)
+}
+
+// NewFileMgrWithStore は store を使う FileMgr を作る。
+// 初回は制御ファイルを書き、2 回目以降はブロックサイズなどが一致するか検証する。
+func NewFileMgrWithStore(store BlockStore, blocksize int, opts ...Option) (*FileMgr, error) {
+	cfg := newConfig(opts)
+
+	header, err := openHeader(store, blocksize, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("file: open: %w", err)
+	}
+
+	var frame []byte
+	if cfg.checksums {
+		frame = make([]byte, blocksize+PAGE_TRAILER_BYTES)
 	}
 
 	return &FileMgr{
-		dbDir: dbDir,
+		store: store,
 		blocksize: blocksize,
-		openFiles: make(map[string]*os.File),
+		header: header,
+		checksums: cfg.checksums,
+		frame: frame,
+		mu: sync.Mutex{},
+	}, nil
+}
+
+// NewMigrationFileMgr は Migration の中で h の形式のブロックを読み書きする FileMgr を作る。
+// 制御ファイルは読み書きしない。store は呼び出し側のものなので Close しないこと。
+func NewMigrationFileMgr(store BlockStore, h Header) *FileMgr {
+	var frame []byte
+	if h.Checksums {
+		frame = make([]byte, h.BlockSize+PAGE_TRAILER_BYTES)
+	}
+
+	return &FileMgr{
+		store: store,
+		blocksize: h.BlockSize,
+		header: h,
+		checksums: h.Checksums,
+		frame: frame,
 		mu: sync.Mutex{},
 	}
 }
 
-func (fm *FileMgr) Read(blk *BlockId, p *Page) {
+// Read はブロックの内容をページに読み込む。
+// ファイル末尾より先のブロックはゼロ埋めされたページとして扱う。
+func (fm *FileMgr) Read(blk *BlockId, p *Page) error {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 
-	file, err := fm.getFile(blk.FileName())
+	if blk.Number() < 0 {
+		return fmt.Errorf("file: read %v: %w", blk, ErrBlockOutOfRange)
+	}
+
+	if !fm.checksums {
+		return fm.readBlock(blk, p.contents())
+	}
+
+	if err := fm.readBlock(blk, fm.frame); err != nil {
+		return err
+	}
+	lsn, err := verifyTrailer(blk, fm.frame, p.contents())
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("file: read %v: %w", blk, err)
 	}
+	p.SetLSN(lsn)
 
-	file.Seek(int64(blk.Number()*fm.blocksize), io.SeekStart)
-	file.Read(p.contents())
+	return nil
 }
 
-func (fm *FileMgr) Write(blk *BlockId, p *Page) {
+func (fm *FileMgr) Write(blk *BlockId, p *Page) error {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 
-	file, err := fm.getFile(blk.FileName())
+	return fm.write(blk, p)
+}
+
+// write は p を blk に書く。fm.mu を取った状態で呼ぶ。
+func (fm *FileMgr) write(blk *BlockId, p *Page) error {
+	if blk.Number() < 0 {
+		return fmt.Errorf("file: write %v: %w", blk, ErrBlockOutOfRange)
+	}
+
+	b := p.contents()
+	if fm.checksums {
+		stampTrailer(fm.frame, b, p.LSN())
+		b = fm.frame
+	}
+
+	n, err := fm.store.WriteAt(blk.FileName(), b, fm.offset(blk))
+	if err != nil {
+		return fmt.Errorf("file: write %v: %w", blk, err)
+	}
+	if n < len(b) {
+		return fmt.Errorf("file: write %v: wrote %d of %d bytes: %w", blk, n, len(b), ErrShortWrite)
+	}
+
+	return nil
+}
+
+// VerifyFile はファイルの全ブロックのチェックサムを検証し、壊れているブロックを返す。
+// チェックサムが無効な場合は何もしない。
+func (fm *FileMgr) VerifyFile(filename string) ([]*CorruptBlockError, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if !fm.checksums {
+		return nil, nil
+	}
+
+	length, err := fm.length(filename)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("file: verify %s: %w", filename, err)
 	}
 
-	file.Seek(int64(blk.Number()*fm.blocksize), io.SeekStart)
-	file.Write(p.contents())
+	var corrupted []*CorruptBlockError
+	contents := make([]byte, fm.blocksize)
+	for i := 0; i < length; i++ {
+		blk := NewBlockId(filename, i)
+		if err := fm.readBlock(blk, fm.frame); err != nil {
+			return nil, err
+		}
+		var cerr *CorruptBlockError
+		if _, err := verifyTrailer(blk, fm.frame, contents); errors.As(err, &cerr) {
+			corrupted = append(corrupted, cerr)
+		}
+	}
+
+	return corrupted, nil
 }
 
-func (fm *FileMgr) Append(filename string) *BlockId {
+// Sync はそれまでに Write したファイルの内容を stable storage へ書き出す。
+// どこまで保証されるかは BlockStore の設定による。
+func (fm *FileMgr) Sync(filename string) error {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 
-	return NewBlockId(filename, fm.Length(filename))
+	if err := fm.store.Sync(filename); err != nil {
+		return fmt.Errorf("file: sync %s: %w", filename, err)
+	}
+
+	return nil
 }
 
-func (fm *FileMgr) Length(filename string) int {
-	file, err := fm.getFile(filename)
+// Append は filename の末尾にゼロで埋めたブロックを書き足し、そのブロックを返す。
+// 書き足してから返すので、続けて Append しても同じブロックは返らない。
+func (fm *FileMgr) Append(filename string) (*BlockId, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	length, err := fm.length(filename)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("file: append %s: %w", filename, err)
 	}
 
-	bytes, err := file.Seek(0, io.SeekEnd)
+	blk := NewBlockId(filename, length)
+	if err := fm.write(blk, NewPage(fm.blocksize)); err != nil {
+		return nil, fmt.Errorf("file: append %s: %w", filename, err)
+	}
+	return blk, nil
+}
+
+func (fm *FileMgr) Length(filename string) (int, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	length, err := fm.length(filename)
 	if err != nil {
-		panic(err)
+		return 0, fmt.Errorf("file: length %s: %w", filename, err)
 	}
-	
-	return int(bytes) / fm.BlockSize()
+
+	return length, nil
+}
+
+// FileSize はファイルのディスク上のバイト数を返す。
+func (fm *FileMgr) FileSize(filename string) (int64, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	size, err := fm.store.Size(filename)
+	if err != nil {
+		return 0, fmt.Errorf("file: size %s: %w", filename, err)
+	}
+
+	return size, nil
+}
+
+func (fm *FileMgr) Remove(filename string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if err := fm.store.Remove(filename); err != nil {
+		return fmt.Errorf("file: remove %s: %w", filename, err)
+	}
+
+	return nil
+}
+
+func (fm *FileMgr) Rename(oldname string, newname string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if err := fm.store.Rename(oldname, newname); err != nil {
+		return fmt.Errorf("file: rename %s to %s: %w", oldname, newname, err)
+	}
+
+	return nil
+}
+
+// Files は store にあるファイル名を辞書順に返す。
+func (fm *FileMgr) Files() ([]string, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	names, err := fm.store.List()
+	if err != nil {
+		return nil, fmt.Errorf("file: list: %w", err)
+	}
+
+	return names, nil
 }
 
 func (fm *FileMgr) BlockSize() int {
 	return fm.blocksize
 }
 
-func (fm *FileMgr) Close() {
+func (fm *FileMgr) Store() BlockStore {
+	return fm.store
+}
+
+func (fm *FileMgr) Header() Header {
+	return fm.header
+}
+
+func (fm *FileMgr) Checksums() bool {
+	return fm.checksums
+}
+
+func (fm *FileMgr) Close() error {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 
-	for _, file := range fm.openFiles {
-		file.Close()
+	if err := fm.store.Close(); err != nil {
+		return fmt.Errorf("file: close: %w", err)
 	}
+
+	return nil
 }
 
-func (fm *FileMgr) getFile(filename string) (*os.File, error) {
-	file, ok := fm.openFiles[filename]
-	if ok {
-		return file, nil
-	}
-	
-	file, err := os.OpenFile(fmt.Sprintf("%s/%s", fm.dbDir, filename), os.O_RDWR|os.O_CREATE, 0777)
+func (fm *FileMgr) length(filename string) (int, error) {
+	size, err := fm.store.Size(filename)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+
+	return int(size) / fm.physicalBlockSize(), nil
+}
+
+// readBlock はブロック 1 つ分を b に読み込む。
+// ファイル末尾より先のブロックはゼロ埋めする。
+func (fm *FileMgr) readBlock(blk *BlockId, b []byte) error {
+	n, err := fm.store.ReadAt(blk.FileName(), b, fm.offset(blk))
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("file: read %v: %w", blk, err)
+	}
+	if n == 0 {
+		clear(b)
+		return nil
+	}
+	if n < len(b) {
+		return fmt.Errorf("file: read %v: got %d of %d bytes: %w", blk, n, len(b), ErrShortRead)
 	}
 
-	fm.openFiles[filename] = file
-	return file, nil
+	return nil
+}
+
+func (fm *FileMgr) offset(blk *BlockId) int64 {
+	return int64(blk.Number()) * int64(fm.physicalBlockSize())
+}
+
+// physicalBlockSize はトレイラを含むディスク上のブロックサイズ。
+func (fm *FileMgr) physicalBlockSize() int {
+	if fm.checksums {
+		return fm.blocksize + PAGE_TRAILER_BYTES
+	}
+	return fm.blocksize
 }
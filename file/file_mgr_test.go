@@ -1,28 +1,31 @@
 package file_test
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/nfphys/simpledb-go/file"
 )
 
-func setup(blocksize int) *file.FileMgr {
-	dbDir := filepath.Join(os.TempDir(), "testdb")
-	os.RemoveAll(dbDir)
-	return file.NewFileMgr(dbDir, blocksize)
+func setup(t *testing.T, blocksize int) *file.FileMgr {
+	fm, err := file.NewFileMgrWithStore(file.NewMemStore(), blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return fm
 }
 
 func cleanup(fm *file.FileMgr) {
 	fm.Close()
-	os.RemoveAll(filepath.Join(os.TempDir(), "testdb"))
 }
 
 func TestWriteRead(t *testing.T) {
 	// Given
 	blocksize := 4096
-	fm := setup(blocksize)
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
 	blk := file.NewBlockId("testfile", 0)
@@ -33,14 +36,18 @@ func TestWriteRead(t *testing.T) {
 	p1.SetInt(100, 123)
 
 	// When
-	fm.Write(blk, p1)
-	fm.Read(blk, p2)
+	if err := fm.Write(blk, p1); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := fm.Read(blk, p2); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
 	// Then
-	if readStr := p2.GetString(0); readStr != "Hello, World!" {
+	if readStr, _ := p2.GetString(0); readStr != "Hello, World!" {
 		t.Errorf("Expected string 'Hello, World!', got '%s'", readStr)
 	}
-	if readInt := p2.GetInt(100); readInt != 123 {
+	if readInt, _ := p2.GetInt(100); readInt != 123 {
 		t.Errorf("Expected int 123, got %d", readInt)
 	}
 }
@@ -48,7 +55,7 @@ func TestWriteRead(t *testing.T) {
 func TestAppend(t *testing.T) {
 	// Given
 	blocksize := 4096
-	fm := setup(blocksize)
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
 	blk := file.NewBlockId("testfile", 3)
@@ -56,21 +63,27 @@ func TestAppend(t *testing.T) {
 	fm.Write(blk, p)
 
 	// When
-	blk2 := fm.Append("testfile")
+	blk2, err := fm.Append("testfile")
 
 	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	if blk2.FileName() != "testfile" {
 		t.Errorf("Expected filename 'testfile', got '%s'", blk2.FileName())
 	}
 	if blk2.Number() != 4 {
 		t.Errorf("Expected block number 4, got %d", blk2.Number())
 	}
+	if n, _ := fm.Length("testfile"); n != 5 {
+		t.Errorf("Expected appended block to extend the file to 5 blocks, got %d", n)
+	}
 }
 
 func TestLength(t *testing.T) {
 	// Given
 	blocksize := 4096
-	fm := setup(blocksize)
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
 	blk := file.NewBlockId("testfile", 3)
@@ -78,10 +91,355 @@ func TestLength(t *testing.T) {
 	fm.Write(blk, p)
 
 	// When
-	length := fm.Length("testfile")
+	length, err := fm.Length("testfile")
 
 	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	if length != 4 {
 		t.Errorf("Expected length 4, got %d", length)
 	}
 }
+
+func TestReadPastEndOfFile(t *testing.T) {
+	// Given
+	blocksize := 4096
+	fm := setup(t, blocksize)
+	defer cleanup(fm)
+
+	blk := file.NewBlockId("testfile", 5)
+	p := file.NewPage(blocksize)
+	p.SetInt(0, 123)
+
+	// When
+	err := fm.Read(blk, p)
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if v, _ := p.GetInt(0); v != 0  {
+		t.Errorf("Expected zeroed page, got %d", v)
+	}
+}
+
+func TestReadShortBlock(t *testing.T) {
+	// Given
+	blocksize := 4096
+	dbDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dbDir, "testfile"), make([]byte, blocksize+10), 0666); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	fm, err := file.NewFileMgr(dbDir, blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	// When
+	err = fm.Read(file.NewBlockId("testfile", 1), file.NewPage(blocksize))
+
+	// Then
+	if !errors.Is(err, file.ErrShortRead) {
+		t.Errorf("Expected ErrShortRead, got %v", err)
+	}
+}
+
+func TestNegativeBlockNumber(t *testing.T) {
+	// Given
+	blocksize := 4096
+	fm := setup(t, blocksize)
+	defer cleanup(fm)
+
+	blk := file.NewBlockId("testfile", -1)
+	p := file.NewPage(blocksize)
+
+	// When
+	readErr := fm.Read(blk, p)
+	writeErr := fm.Write(blk, p)
+
+	// Then
+	if !errors.Is(readErr, file.ErrBlockOutOfRange) {
+		t.Errorf("Expected ErrBlockOutOfRange, got %v", readErr)
+	}
+	if !errors.Is(writeErr, file.ErrBlockOutOfRange) {
+		t.Errorf("Expected ErrBlockOutOfRange, got %v", writeErr)
+	}
+}
+
+type countingFile struct {
+	*os.File
+	syncs *int
+}
+
+func (f countingFile) Sync() error {
+	*f.syncs++
+	return f.File.Sync()
+}
+
+func openCountingFile(syncs *int, flags *int) file.OpenFileFunc {
+	return func(name string, flag int, perm os.FileMode) (file.File, error) {
+		*flags = flag
+		f, err := os.OpenFile(name, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		return countingFile{File: f, syncs: syncs}, nil
+	}
+}
+
+func TestSyncMode(t *testing.T) {
+	tests := []struct {
+		mode file.SyncMode
+		expectedSyncs int
+	}{
+		{file.SYNC_NONE, 0},
+		{file.SYNC_FSYNC, 2},
+		{file.SYNC_FDATASYNC, 2}, // countingFile は Datasync を持たないので Sync で代用される
+		{file.SYNC_DSYNC, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			// Given
+			blocksize := 4096
+			syncs, flags := 0, 0
+			fm, err := file.NewFileMgr(t.TempDir(), blocksize, file.WithSyncMode(tt.mode), file.WithOpenFile(openCountingFile(&syncs, &flags)))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			defer cleanup(fm)
+
+			blk := file.NewBlockId("testfile", 0)
+			p := file.NewPage(blocksize)
+			syncs = 0 // 制御ファイルの作成分は数えない
+
+			// When
+			for i := 0; i < 2; i++ {
+				if err := fm.Write(blk, p); err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if err := fm.Sync("testfile"); err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+			}
+
+			// Then
+			if syncs != tt.expectedSyncs {
+				t.Errorf("Expected %d syncs, got %d", tt.expectedSyncs, syncs)
+			}
+			if dsync := flags&^(os.O_RDWR|os.O_CREATE) != 0; dsync != (tt.mode == file.SYNC_DSYNC) {
+				t.Errorf("Expected synchronous open flag to be %v, got flags %#x", tt.mode == file.SYNC_DSYNC, flags)
+			}
+		})
+	}
+}
+
+func TestOSStorePersistsAcrossReopen(t *testing.T) {
+	// Given
+	blocksize := 4096
+	dbDir := t.TempDir()
+	fm, err := file.NewFileMgr(dbDir, blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	blk := file.NewBlockId("testfile", 1)
+	p := file.NewPage(blocksize)
+	p.SetString(0, "Hello, World!")
+	if err := fm.Write(blk, p); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	fm, err = file.NewFileMgr(dbDir, blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	p = file.NewPage(blocksize)
+	err = fm.Read(blk, p)
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if s, _ := p.GetString(0); s != "Hello, World!" {
+		t.Errorf("Expected string 'Hello, World!', got '%s'", s)
+	}
+	if length, _ := fm.Length("testfile"); length != 2 {
+		t.Errorf("Expected length 2, got %d", length)
+	}
+}
+
+func TestRenameAndRemove(t *testing.T) {
+	stores := map[string]func(t *testing.T) file.BlockStore{
+		"mem": func(t *testing.T) file.BlockStore { return file.NewMemStore() },
+		"os": func(t *testing.T) file.BlockStore {
+			store, err := file.NewOSStore(t.TempDir())
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fm, err := file.NewFileMgrWithStore(newStore(t), 400)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			defer cleanup(fm)
+
+			p := file.NewPage(400)
+			p.SetInt(0, 42)
+			for _, filename := range []string{"a", "b"} {
+				if err := fm.Write(file.NewBlockId(filename, 0), p); err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+			}
+
+			// When
+			if err := fm.Rename("a", "archive/a"); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if err := fm.Remove("b"); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			// Then
+			files, err := fm.Files()
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(files) != 2 || files[0] != "archive/a" || files[1] != file.HEADER_FILE {
+				t.Errorf("Expected [archive/a %s], got %v", file.HEADER_FILE, files)
+			}
+			p = file.NewPage(400)
+			if err := fm.Read(file.NewBlockId("archive/a", 0), p); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if v, _ := p.GetInt(0); v != 42  {
+				t.Errorf("Expected 42, got %d", v)
+			}
+		})
+	}
+}
+
+func TestChecksumsWriteRead(t *testing.T) {
+	// Given
+	blocksize := 4096
+	fm, err := file.NewFileMgrWithStore(file.NewMemStore(), blocksize, file.WithChecksums())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	blk := file.NewBlockId("testfile", 1)
+	p1 := file.NewPage(blocksize)
+	p1.SetString(0, "Hello, World!")
+	p1.SetLSN(42)
+
+	// When
+	if err := fm.Write(blk, p1); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	p2 := file.NewPage(blocksize)
+	err = fm.Read(blk, p2)
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if s, _ := p2.GetString(0); s != "Hello, World!" {
+		t.Errorf("Expected string 'Hello, World!', got '%s'", s)
+	}
+	if p2.LSN() != 42 {
+		t.Errorf("Expected LSN 42, got %d", p2.LSN())
+	}
+	if length, _ := fm.Length("testfile"); length != 2 {
+		t.Errorf("Expected length 2, got %d", length)
+	}
+}
+
+func TestChecksumsDetectCorruption(t *testing.T) {
+	// Given
+	blocksize := 4096
+	store := file.NewMemStore()
+	fm, err := file.NewFileMgrWithStore(store, blocksize, file.WithChecksums())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	p := file.NewPage(blocksize)
+	p.SetString(0, "Hello, World!")
+	for i := 0; i < 3; i++ {
+		if err := fm.Write(file.NewBlockId("testfile", i), p); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	// ブロック 1 の途中だけが書き換わった (torn page) 状態を作る
+	offset := int64(blocksize+file.PAGE_TRAILER_BYTES) + 4
+	if _, err := store.WriteAt("testfile", []byte("Jello"), offset); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	err = fm.Read(file.NewBlockId("testfile", 1), file.NewPage(blocksize))
+	corrupted, verr := fm.VerifyFile("testfile")
+
+	// Then
+	var cerr *file.CorruptBlockError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Expected CorruptBlockError, got %v", err)
+	}
+	if !errors.Is(err, file.ErrCorruptBlock) {
+		t.Errorf("Expected ErrCorruptBlock, got %v", err)
+	}
+	if !cerr.Blk.Equals(file.NewBlockId("testfile", 1)) {
+		t.Errorf("Expected corrupt block %v, got %v", file.NewBlockId("testfile", 1), cerr.Blk)
+	}
+	if verr != nil {
+		t.Fatalf("Expected no error, got %v", verr)
+	}
+	if len(corrupted) != 1 || corrupted[0].Blk.Number() != 1 {
+		t.Errorf("Expected only block 1 to be corrupted, got %v", corrupted)
+	}
+}
+
+func TestReadDoesNotCreateFile(t *testing.T) {
+	// Given
+	fm, err := file.NewFileMgr(t.TempDir(), 400)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	// When
+	p := file.NewPage(400)
+	if err := fm.Read(file.NewBlockId("missing", 0), p); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	length, err := fm.Length("missing")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Then
+	if length != 0 {
+		t.Errorf("Expected length 0, got %d", length)
+	}
+	files, _ := fm.Files()
+	if slices.Contains(files, "missing") {
+		t.Errorf("Expected reading not to create the file, got %v", files)
+	}
+}
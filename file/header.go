@@ -0,0 +1,143 @@
+package file
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+const (
+	HEADER_FILE = "simpledb.ctl"
+	FORMAT_VERSION = 2 // 2: ログのブロックにヘッダとレコードのチェックサムが付いた
+)
+
+const (
+	headerMagic = "SDBC"
+	headerBytes = 32 // layout: [magic(4)][version][blocksize][byte order][flags][created(int64)][crc32c]
+	byteOrderMark = 0x01020304
+	flagChecksums = 1 << 0
+)
+
+var (
+	ErrInvalidHeader = errors.New("invalid database header")
+	ErrHeaderMismatch = errors.New("database header mismatch")
+	ErrUnsupportedVersion = errors.New("unsupported format version")
+)
+
+// Header はデータベースの制御ファイルに記録される、ファイル群の解釈に必要な情報。
+type Header struct {
+	Version int
+	BlockSize int
+	LittleEndian bool
+	Checksums bool
+	CreatedAt time.Time
+}
+
+// Migration は Header.Version のフォーマットのファイル群を Version+1 のフォーマットに書き換える。
+type Migration func(store BlockStore, h Header) error
+
+// ReadHeader は store の制御ファイルを読む。制御ファイルがなければ ok は false。
+func ReadHeader(store BlockStore) (h Header, ok bool, err error) {
+	b := make([]byte, headerBytes)
+	n, err := store.ReadAt(HEADER_FILE, b, 0)
+	if err != nil && err != io.EOF {
+		return Header{}, false, err
+	}
+	if n == 0 {
+		return Header{}, false, nil
+	}
+	if n < headerBytes {
+		return Header{}, false, fmt.Errorf("%w: got %d of %d bytes", ErrInvalidHeader, n, headerBytes)
+	}
+
+	if string(b[0:4]) != headerMagic {
+		return Header{}, false, fmt.Errorf("%w: bad magic %q", ErrInvalidHeader, b[0:4])
+	}
+	stored := binary.LittleEndian.Uint32(b[28:32])
+	if computed := crc32.Checksum(b[:28], castagnoli); stored != computed {
+		return Header{}, false, fmt.Errorf("%w: stored checksum %#08x, computed %#08x", ErrInvalidHeader, stored, computed)
+	}
+
+	flags := binary.LittleEndian.Uint32(b[16:20])
+	return Header{
+		Version: int(binary.LittleEndian.Uint32(b[4:8])),
+		BlockSize: int(binary.LittleEndian.Uint32(b[8:12])),
+		LittleEndian: binary.LittleEndian.Uint32(b[12:16]) == byteOrderMark,
+		Checksums: flags&flagChecksums != 0,
+		CreatedAt: time.Unix(0, int64(binary.LittleEndian.Uint64(b[20:28]))),
+	}, true, nil
+}
+
+// WriteHeader は store の制御ファイルを h で書き換えて Sync する。
+func WriteHeader(store BlockStore, h Header) error {
+	b := make([]byte, headerBytes)
+	copy(b[0:4], headerMagic)
+	binary.LittleEndian.PutUint32(b[4:8], uint32(h.Version))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(h.BlockSize))
+	if h.LittleEndian {
+		binary.LittleEndian.PutUint32(b[12:16], byteOrderMark)
+	} else {
+		binary.BigEndian.PutUint32(b[12:16], byteOrderMark)
+	}
+	var flags uint32
+	if h.Checksums {
+		flags |= flagChecksums
+	}
+	binary.LittleEndian.PutUint32(b[16:20], flags)
+	binary.LittleEndian.PutUint64(b[20:28], uint64(h.CreatedAt.UnixNano()))
+	binary.LittleEndian.PutUint32(b[28:32], crc32.Checksum(b[:28], castagnoli))
+
+	if _, err := store.WriteAt(HEADER_FILE, b, 0); err != nil {
+		return err
+	}
+	return store.Sync(HEADER_FILE)
+}
+
+// openHeader は初回なら制御ファイルを作り、そうでなければ cfg と矛盾しないか検証する。
+// 古いフォーマットは登録された Migration で順に更新する。
+func openHeader(store BlockStore, blocksize int, cfg *config) (Header, error) {
+	h, ok, err := ReadHeader(store)
+	if err != nil {
+		return Header{}, err
+	}
+	if !ok {
+		h = Header{
+			Version: FORMAT_VERSION,
+			BlockSize: blocksize,
+			LittleEndian: true,
+			Checksums: cfg.checksums,
+			CreatedAt: time.Now().Round(0),
+		}
+		return h, WriteHeader(store, h)
+	}
+
+	for h.Version < FORMAT_VERSION {
+		migrate, ok := cfg.migrations[h.Version]
+		if !ok {
+			return Header{}, fmt.Errorf("%w: %d (no migration to %d)", ErrUnsupportedVersion, h.Version, h.Version+1)
+		}
+		if err := migrate(store, h); err != nil {
+			return Header{}, fmt.Errorf("migrate format %d to %d: %w", h.Version, h.Version+1, err)
+		}
+		h.Version++
+		if err := WriteHeader(store, h); err != nil {
+			return Header{}, err
+		}
+	}
+
+	switch {
+	case h.Version > FORMAT_VERSION:
+		return Header{}, fmt.Errorf("%w: %d (this build supports up to %d)", ErrUnsupportedVersion, h.Version, FORMAT_VERSION)
+	case !h.LittleEndian:
+		return Header{}, fmt.Errorf("%w: byte order: stored big endian, expected little endian", ErrHeaderMismatch)
+	case h.BlockSize != blocksize:
+		return Header{}, fmt.Errorf("%w: block size: stored %d, requested %d", ErrHeaderMismatch, h.BlockSize, blocksize)
+	case h.Checksums != cfg.checksums:
+		return Header{}, fmt.Errorf("%w: checksums: stored %v, requested %v", ErrHeaderMismatch, h.Checksums, cfg.checksums)
+	}
+
+	return h, nil
+}
@@ -0,0 +1,174 @@
+package file_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nfphys/simpledb-go/file"
+)
+
+func TestHeaderWrittenOnFirstOpen(t *testing.T) {
+	// Given
+	store := file.NewMemStore()
+
+	// When
+	fm, err := file.NewFileMgrWithStore(store, 4096, file.WithChecksums())
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	h, ok, err := file.ReadHeader(store)
+	if err != nil || !ok {
+		t.Fatalf("Expected header to be written, got ok=%v err=%v", ok, err)
+	}
+	if h.Version != file.FORMAT_VERSION {
+		t.Errorf("Expected version %d, got %d", file.FORMAT_VERSION, h.Version)
+	}
+	if h.BlockSize != 4096 {
+		t.Errorf("Expected block size 4096, got %d", h.BlockSize)
+	}
+	if !h.LittleEndian {
+		t.Errorf("Expected little endian, got big endian")
+	}
+	if !h.Checksums {
+		t.Errorf("Expected checksums to be recorded")
+	}
+	if time.Since(h.CreatedAt) > time.Minute {
+		t.Errorf("Expected recent creation time, got %v", h.CreatedAt)
+	}
+	if got := fm.Header(); got.Version != h.Version || got.BlockSize != h.BlockSize || !got.CreatedAt.Equal(h.CreatedAt) {
+		t.Errorf("Expected FileMgr header %+v, got %+v", h, fm.Header())
+	}
+}
+
+func TestHeaderMismatch(t *testing.T) {
+	tests := []struct {
+		name string
+		blocksize int
+		opts []file.Option
+	}{
+		{"block size", 32, nil},
+		{"checksums", 4096, []file.Option{file.WithChecksums()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			store := file.NewMemStore()
+			fm, err := file.NewFileMgrWithStore(store, 4096)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			fm.Close()
+
+			// When
+			_, err = file.NewFileMgrWithStore(store, tt.blocksize, tt.opts...)
+
+			// Then
+			if !errors.Is(err, file.ErrHeaderMismatch) {
+				t.Errorf("Expected ErrHeaderMismatch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestHeaderFromNewerVersion(t *testing.T) {
+	// Given
+	store := file.NewMemStore()
+	err := file.WriteHeader(store, file.Header{
+		Version: file.FORMAT_VERSION + 1,
+		BlockSize: 4096,
+		LittleEndian: true,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	_, err = file.NewFileMgrWithStore(store, 4096)
+
+	// Then
+	if !errors.Is(err, file.ErrUnsupportedVersion) {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestHeaderMigration(t *testing.T) {
+	// Given
+	store := file.NewMemStore()
+	err := file.WriteHeader(store, file.Header{
+		Version: file.FORMAT_VERSION - 1,
+		BlockSize: 4096,
+		LittleEndian: true,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	migrated := []int{}
+	migrate := func(store file.BlockStore, h file.Header) error {
+		migrated = append(migrated, h.Version)
+		return nil
+	}
+
+	// When
+	fm, err := file.NewFileMgrWithStore(store, 4096, file.WithMigration(file.FORMAT_VERSION-1, migrate))
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	if len(migrated) != 1 || migrated[0] != file.FORMAT_VERSION-1 {
+		t.Errorf("Expected migration from %d, got %v", file.FORMAT_VERSION-1, migrated)
+	}
+	h, _, _ := file.ReadHeader(store)
+	if h.Version != file.FORMAT_VERSION {
+		t.Errorf("Expected stored version %d, got %d", file.FORMAT_VERSION, h.Version)
+	}
+}
+
+func TestHeaderWithoutMigration(t *testing.T) {
+	// Given
+	store := file.NewMemStore()
+	err := file.WriteHeader(store, file.Header{
+		Version: file.FORMAT_VERSION - 1,
+		BlockSize: 4096,
+		LittleEndian: true,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	_, err = file.NewFileMgrWithStore(store, 4096)
+
+	// Then
+	if !errors.Is(err, file.ErrUnsupportedVersion) {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestCorruptHeader(t *testing.T) {
+	// Given
+	store := file.NewMemStore()
+	if _, err := store.WriteAt(file.HEADER_FILE, []byte("not a header, definitely not one"), 0); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	_, err := file.NewFileMgrWithStore(store, 4096)
+
+	// Then
+	if !errors.Is(err, file.ErrInvalidHeader) {
+		t.Errorf("Expected ErrInvalidHeader, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// MemStore はブロックをメモリ上にだけ保持する BlockStore。
+// テストや使い捨てのキャッシュ用。Close しても内容は残るので、
+// 同じ MemStore から FileMgr を作り直せば再起動を模擬できる。
+type MemStore struct {
+	files map[string][]byte
+	mu sync.RWMutex
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		files: make(map[string][]byte),
+		mu: sync.RWMutex{},
+	}
+}
+
+func (s *MemStore) ReadAt(filename string, b []byte, off int64) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data := s.files[filename]
+	if off >= int64(len(data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(b, data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *MemStore) WriteAt(filename string, b []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := s.files[filename]
+	if end := off + int64(len(b)); end > int64(len(data)) {
+		grown := make([]byte, end)
+		copy(grown, data)
+		data = grown
+	}
+
+	n := copy(data[off:], b)
+	s.files[filename] = data
+	return n, nil
+}
+
+func (s *MemStore) Size(filename string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.files[filename])), nil
+}
+
+func (s *MemStore) Sync(filename string) error {
+	return nil
+}
+
+func (s *MemStore) Remove(filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.files, filename)
+	return nil
+}
+
+func (s *MemStore) Rename(oldname string, newname string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.files[oldname]
+	if !ok {
+		return fmt.Errorf("rename %s: %w", oldname, fs.ErrNotExist)
+	}
+	delete(s.files, oldname)
+	s.files[newname] = data
+	return nil
+}
+
+func (s *MemStore) List() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Sorted(maps.Keys(s.files)), nil
+}
+
+func (s *MemStore) Close() error {
+	return nil
+}
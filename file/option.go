@@ -0,0 +1,55 @@
+package file
+
+// Option は FileMgr と OSStore の設定を変える。
+// OSStore にしか意味のない設定は NewFileMgrWithStore では無視される。
+type Option func(*config)
+
+type config struct {
+	syncMode SyncMode
+	openFile OpenFileFunc
+	checksums bool
+	migrations map[int]Migration
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		syncMode: SYNC_FSYNC,
+		openFile: openOSFile,
+		checksums: false,
+		migrations: make(map[int]Migration),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithSyncMode は Sync が stable storage へ書き出す方法を指定する。
+// デフォルトは SYNC_FSYNC。
+func WithSyncMode(mode SyncMode) Option {
+	return func(cfg *config) {
+		cfg.syncMode = mode
+	}
+}
+
+// WithOpenFile はファイルを開く関数を差し替える。
+func WithOpenFile(openFile OpenFileFunc) Option {
+	return func(cfg *config) {
+		cfg.openFile = openFile
+	}
+}
+
+// WithChecksums は各ブロックの末尾に page LSN と CRC32C を持つトレイラを付ける。
+// Write がトレイラを書き、Read が検証する。
+func WithChecksums() Option {
+	return func(cfg *config) {
+		cfg.checksums = true
+	}
+}
+
+// WithMigration は制御ファイルのフォーマットが from のとき、from+1 へ更新する Migration を登録する。
+func WithMigration(from int, migrate Migration) Option {
+	return func(cfg *config) {
+		cfg.migrations[from] = migrate
+	}
+}
@@ -0,0 +1,231 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File は OSStore が使うファイル操作の最小集合。
+// テストでは OpenFileFunc を差し替えて Sync の回数などを観測できる。
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Close() error
+}
+
+type OpenFileFunc func(name string, flag int, perm os.FileMode) (File, error)
+
+// datasyncer は fdatasync に相当する操作を持つ File。
+type datasyncer interface {
+	Datasync() error
+}
+
+type osFile struct {
+	*os.File
+}
+
+func openOSFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &osFile{File: f}, nil
+}
+
+// OSStore は dbDir 以下の OS のファイルにブロックを格納する。
+type OSStore struct {
+	dbDir string
+	syncMode SyncMode
+	openFile OpenFileFunc
+	openFiles map[string]File
+	mu sync.Mutex
+}
+
+func NewOSStore(dbDir string, opts ...Option) (*OSStore, error) {
+	err := os.MkdirAll(dbDir, 0777)
+	if err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("file: create db directory %s: %w", dbDir, err)
+	}
+
+	cfg := newConfig(opts)
+	return &OSStore{
+		dbDir: dbDir,
+		syncMode: cfg.syncMode,
+		openFile: cfg.openFile,
+		openFiles: make(map[string]File),
+		mu: sync.Mutex{},
+	}, nil
+}
+
+func (s *OSStore) SyncMode() SyncMode {
+	return s.syncMode
+}
+
+// ReadAt はまだないファイルを空のファイルとして読む。読むだけではファイルを作らない。
+func (s *OSStore) ReadAt(filename string, b []byte, off int64) (int, error) {
+	file, err := s.getFile(filename, false)
+	if err != nil {
+		return 0, err
+	}
+	if file == nil {
+		return 0, io.EOF
+	}
+	return file.ReadAt(b, off)
+}
+
+func (s *OSStore) WriteAt(filename string, b []byte, off int64) (int, error) {
+	file, err := s.getFile(filename, true)
+	if err != nil {
+		return 0, err
+	}
+	return file.WriteAt(b, off)
+}
+
+func (s *OSStore) Size(filename string) (int64, error) {
+	file, err := s.getFile(filename, false)
+	if err != nil {
+		return 0, err
+	}
+	if file == nil {
+		return 0, nil
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *OSStore) Sync(filename string) error {
+	file, err := s.getFile(filename, false)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		return nil
+	}
+
+	switch s.syncMode {
+	case SYNC_FSYNC:
+		return file.Sync()
+	case SYNC_FDATASYNC:
+		if ds, ok := file.(datasyncer); ok {
+			return ds.Datasync()
+		}
+		return file.Sync()
+	default:
+		return nil
+	}
+}
+
+func (s *OSStore) Remove(filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.closeFile(filename); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(s.dbDir, filename))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Rename は oldname を newname に移す。newname のディレクトリがなければ作る。
+func (s *OSStore) Rename(oldname string, newname string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.closeFile(oldname); err != nil {
+		return err
+	}
+	newpath := filepath.Join(s.dbDir, newname)
+	if err := os.MkdirAll(filepath.Dir(newpath), 0777); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(s.dbDir, oldname), newpath)
+}
+
+// List はサブディレクトリ内のファイルも "archive/name" のような / 区切りの名前で返す。
+func (s *OSStore) List() ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(s.dbDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(s.dbDir, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (s *OSStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for filename, file := range s.openFiles {
+		if err := file.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close %s: %w", filename, err))
+		}
+		delete(s.openFiles, filename)
+	}
+
+	return errors.Join(errs...)
+}
+
+// closeFile は開いているファイルを閉じる。s.mu を持って呼ぶこと。
+func (s *OSStore) closeFile(filename string) error {
+	file, ok := s.openFiles[filename]
+	if !ok {
+		return nil
+	}
+	delete(s.openFiles, filename)
+	return file.Close()
+}
+
+// getFile は filename を開く。create が false でファイルがなければ nil を返す。
+func (s *OSStore) getFile(filename string, create bool) (File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, ok := s.openFiles[filename]
+	if ok {
+		return file, nil
+	}
+
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE
+	}
+	if s.syncMode == SYNC_DSYNC {
+		flag |= oDSYNC
+	}
+
+	file, err := s.openFile(filepath.Join(s.dbDir, filename), flag, 0777)
+	if !create && errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.openFiles[filename] = file
+	return file, nil
+}
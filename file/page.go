@@ -2,14 +2,32 @@ package file
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf8"
 )
 
 const (
 	INT_BYTES = 4
+	INT64_BYTES = 8
+	UINT16_BYTES = 2
+	UINT32_BYTES = 4
+	FLOAT64_BYTES = 8
+	BOOL_BYTES = 1
+	TIME_BYTES = 8 // UnixNano を int64 で格納する
+)
+
+var (
+	ErrOffsetOutOfRange = errors.New("offset out of range")
+	ErrPageOverflow = errors.New("page overflow")
+	ErrValueOutOfRange = errors.New("value out of range")
 )
 
 type Page struct {
 	b []byte
+	lsn int // ブロックのトレイラに保存される。チェックサムが無効なら永続化されない
 }
 
 func NewPage(blocksize int) *Page {
@@ -24,30 +42,243 @@ func NewPageFromBytes(b []byte) *Page {
 	}
 }
 
-func (p *Page) GetInt(offset int) int {
-	return int(binary.LittleEndian.Uint32(p.b[offset:offset+INT_BYTES]))
+// GetInt は SetInt で格納した 32 ビットの符号付き整数を返す。
+func (p *Page) GetInt(offset int) (int, error) {
+	b, err := p.slice(offset, INT_BYTES)
+	if err != nil {
+		return 0, err
+	}
+	return int(int32(binary.LittleEndian.Uint32(b))), nil
 }
 
-func (p *Page) SetInt(offset int, n int) {
-	binary.LittleEndian.PutUint32(p.b[offset:offset+INT_BYTES], uint32(n))
+// SetInt は n を 32 ビットの符号付き整数で格納する。
+// math.MinInt32 から math.MaxInt32 の範囲外の値は ErrValueOutOfRange になる。
+func (p *Page) SetInt(offset int, n int) error {
+	if n < math.MinInt32 || n > math.MaxInt32 {
+		return fmt.Errorf("file: int %d at offset %d: %w", n, offset, ErrValueOutOfRange)
+	}
+	b, err := p.slice(offset, INT_BYTES)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(b, uint32(n))
+	return nil
 }
 
-func (p *Page) GetBytes(offset int) []byte {
-	length := p.GetInt(offset) // byte列は、先頭4バイトに長さが格納されている
-	return p.b[offset+INT_BYTES : offset+INT_BYTES+length]
+func (p *Page) GetBytes(offset int) ([]byte, error) {
+	lb, err := p.slice(offset, INT_BYTES)
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(lb) // byte列は、先頭4バイトに長さが格納されている
+	if uint64(length) > uint64(len(p.b)-offset-INT_BYTES) {
+		return nil, fmt.Errorf("file: stored length %d at offset %d exceeds page of %d bytes: %w", length, offset, len(p.b), ErrPageOverflow)
+	}
+
+	return p.b[offset+INT_BYTES : offset+INT_BYTES+int(length)], nil
 }
 
-func (p *Page) SetBytes(offset int, b []byte) {
-	p.SetInt(offset, len(b)) // byte列は、先頭4バイトに長さを格納する
+func (p *Page) SetBytes(offset int, b []byte) error {
+	if _, err := p.slice(offset, INT_BYTES); err != nil {
+		return err
+	}
+	if len(b) > len(p.b)-offset-INT_BYTES {
+		return fmt.Errorf("file: %d bytes at offset %d do not fit in page of %d bytes: %w", len(b), offset, len(p.b), ErrPageOverflow)
+	}
+
+	binary.LittleEndian.PutUint32(p.b[offset:], uint32(len(b))) // byte列は、先頭4バイトに長さを格納する
 	copy(p.b[offset+INT_BYTES:], b)
+	return nil
+}
+
+func (p *Page) GetInt64(offset int) (int64, error) {
+	b, err := p.slice(offset, INT64_BYTES)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b)), nil
+}
+
+func (p *Page) SetInt64(offset int, n int64) error {
+	b, err := p.slice(offset, INT64_BYTES)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(b, uint64(n))
+	return nil
+}
+
+func (p *Page) GetUint16(offset int) (uint16, error) {
+	b, err := p.slice(offset, UINT16_BYTES)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (p *Page) SetUint16(offset int, n uint16) error {
+	b, err := p.slice(offset, UINT16_BYTES)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint16(b, n)
+	return nil
+}
+
+func (p *Page) GetUint32(offset int) (uint32, error) {
+	b, err := p.slice(offset, UINT32_BYTES)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (p *Page) SetUint32(offset int, n uint32) error {
+	b, err := p.slice(offset, UINT32_BYTES)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(b, n)
+	return nil
+}
+
+func (p *Page) GetFloat64(offset int) (float64, error) {
+	b, err := p.slice(offset, FLOAT64_BYTES)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+func (p *Page) SetFloat64(offset int, f float64) error {
+	b, err := p.slice(offset, FLOAT64_BYTES)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+	return nil
+}
+
+func (p *Page) GetBool(offset int) (bool, error) {
+	b, err := p.slice(offset, BOOL_BYTES)
+	if err != nil {
+		return false, err
+	}
+	return b[0] != 0, nil
+}
+
+func (p *Page) SetBool(offset int, v bool) error {
+	b, err := p.slice(offset, BOOL_BYTES)
+	if err != nil {
+		return err
+	}
+	if v {
+		b[0] = 1
+	} else {
+		b[0] = 0
+	}
+	return nil
+}
+
+// GetTime は SetTime で格納した時刻を UTC で返す。精度はナノ秒。
+func (p *Page) GetTime(offset int) (time.Time, error) {
+	n, err := p.GetInt64(offset)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, n).UTC(), nil
+}
+
+// SetTime は t を UnixNano で格納する。表せるのは 1678 年から 2262 年まで。
+func (p *Page) SetTime(offset int, t time.Time) error {
+	return p.SetInt64(offset, t.UnixNano())
+}
+
+func (p *Page) GetString(offset int) (string, error) {
+	b, err := p.GetBytes(offset)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (p *Page) SetString(offset int, s string) error {
+	return p.SetBytes(offset, []byte(s))
+}
+
+// MaxLength は strlen 文字の文字列を格納するのに必要な最大バイト数を返す。
+func MaxLength(strlen int) int {
+	return INT_BYTES + strlen*utf8.UTFMax
+}
+
+// BytesLength は n バイトのバイト列を格納するのに必要なバイト数を返す。
+func BytesLength(n int) int {
+	return INT_BYTES + n
+}
+
+// IntLength から TimeLength は、それぞれの型の値を格納するのに必要なバイト数を返す。
+// レコードの配置を MaxLength と同じように計算できるようにするためのもの。
+func IntLength() int {
+	return INT_BYTES
+}
+
+func Int64Length() int {
+	return INT64_BYTES
+}
+
+func Uint16Length() int {
+	return UINT16_BYTES
+}
+
+func Uint32Length() int {
+	return UINT32_BYTES
+}
+
+func Float64Length() int {
+	return FLOAT64_BYTES
+}
+
+func BoolLength() int {
+	return BOOL_BYTES
+}
+
+func TimeLength() int {
+	return TIME_BYTES
+}
+
+func (p *Page) LSN() int {
+	return p.lsn
+}
+
+func (p *Page) SetLSN(lsn int) {
+	p.lsn = lsn
 }
 
-func (p *Page) GetString(offset int) string {
-	return string(p.GetBytes(offset))
+// CopyFrom は src の内容と LSN をそのまま写す。ページの大きさは同じであること。
+func (p *Page) CopyFrom(src *Page) {
+	copy(p.b, src.b)
+	p.lsn = src.lsn
 }
 
-func (p *Page) SetString(offset int, s string) {
-	p.SetBytes(offset, []byte(s))
+// Clone は内容と LSN を写した新しいページを返す。
+func (p *Page) Clone() *Page {
+	q := NewPage(len(p.b))
+	q.CopyFrom(p)
+	return q
+}
+
+// IsZero はページの内容がすべてゼロなら true を返す。
+func (p *Page) IsZero() bool {
+	return isZero(p.b)
+}
+
+// slice は [offset, offset+n) がページに収まっていればその部分を返す。
+func (p *Page) slice(offset int, n int) ([]byte, error) {
+	if offset < 0 || offset > len(p.b)-n {
+		return nil, fmt.Errorf("file: %d bytes at offset %d in page of %d bytes: %w", n, offset, len(p.b), ErrOffsetOutOfRange)
+	}
+	return p.b[offset : offset+n], nil
 }
 
 // FileMgr用のprivateメソッド
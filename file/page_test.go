@@ -0,0 +1,233 @@
+package file_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nfphys/simpledb-go/file"
+)
+
+func TestTypedAccessors(t *testing.T) {
+	// Given
+	p := file.NewPage(64)
+	now := time.Date(2024, 2, 29, 12, 34, 56, 789, time.UTC)
+
+	// When
+	errs := []error{
+		p.SetInt64(0, math.MinInt64),
+		p.SetFloat64(8, 3.14),
+		p.SetBool(16, true),
+		p.SetTime(17, now),
+		p.SetUint16(25, math.MaxUint16),
+		p.SetUint32(27, math.MaxUint32),
+	}
+
+	// Then
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if n, _ := p.GetInt64(0); n != math.MinInt64 {
+		t.Errorf("Expected %d, got %d", int64(math.MinInt64), n)
+	}
+	if f, _ := p.GetFloat64(8); f != 3.14 {
+		t.Errorf("Expected 3.14, got %v", f)
+	}
+	if b, _ := p.GetBool(16); b != true {
+		t.Errorf("Expected true, got %v", b)
+	}
+	if tm, _ := p.GetTime(17); !tm.Equal(now) {
+		t.Errorf("Expected %v, got %v", now, tm)
+	}
+	if n, _ := p.GetUint16(25); n != math.MaxUint16 {
+		t.Errorf("Expected %d, got %d", math.MaxUint16, n)
+	}
+	if n, _ := p.GetUint32(27); n != math.MaxUint32 {
+		t.Errorf("Expected %d, got %d", uint32(math.MaxUint32), n)
+	}
+}
+
+func TestTypedAccessorsOutOfRange(t *testing.T) {
+	// Given
+	p := file.NewPage(8)
+
+	// When
+	_, getErr := p.GetInt64(1)
+	setErr := p.SetInt64(-1, 1)
+	_, boolErr := p.GetBool(8)
+	inRangeErr := p.SetBool(7, true)
+
+	// Then
+	if !errors.Is(getErr, file.ErrOffsetOutOfRange) {
+		t.Errorf("Expected ErrOffsetOutOfRange, got %v", getErr)
+	}
+	if !errors.Is(setErr, file.ErrOffsetOutOfRange) {
+		t.Errorf("Expected ErrOffsetOutOfRange, got %v", setErr)
+	}
+	if !errors.Is(boolErr, file.ErrOffsetOutOfRange) {
+		t.Errorf("Expected ErrOffsetOutOfRange, got %v", boolErr)
+	}
+	if inRangeErr != nil {
+		t.Errorf("Expected no error, got %v", inRangeErr)
+	}
+}
+
+func TestIntOutOfRange(t *testing.T) {
+	// Given
+	p := file.NewPage(400)
+
+	// When
+	_, getErr := p.GetInt(398)
+	setErr := p.SetInt(-1, 5)
+	inRangeErr := p.SetInt(396, 5)
+
+	// Then
+	if !errors.Is(getErr, file.ErrOffsetOutOfRange) {
+		t.Errorf("Expected ErrOffsetOutOfRange, got %v", getErr)
+	}
+	if !errors.Is(setErr, file.ErrOffsetOutOfRange) {
+		t.Errorf("Expected ErrOffsetOutOfRange, got %v", setErr)
+	}
+	if inRangeErr != nil {
+		t.Errorf("Expected no error, got %v", inRangeErr)
+	}
+}
+
+func TestIntRange(t *testing.T) {
+	// Given
+	p := file.NewPage(16)
+
+	// When
+	minErr := p.SetInt(0, math.MinInt32)
+	maxErr := p.SetInt(4, math.MaxInt32)
+	negErr := p.SetInt(8, -1)
+	overErr := p.SetInt(12, 1<<33+7)
+	underErr := p.SetInt(12, math.MinInt32-1)
+
+	// Then
+	for _, err := range []error{minErr, maxErr, negErr} {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if n, _ := p.GetInt(0); n != math.MinInt32 {
+		t.Errorf("Expected %d, got %d", math.MinInt32, n)
+	}
+	if n, _ := p.GetInt(4); n != math.MaxInt32 {
+		t.Errorf("Expected %d, got %d", math.MaxInt32, n)
+	}
+	if n, _ := p.GetInt(8); n != -1 {
+		t.Errorf("Expected -1, got %d", n)
+	}
+	for _, err := range []error{overErr, underErr} {
+		if !errors.Is(err, file.ErrValueOutOfRange) {
+			t.Errorf("Expected ErrValueOutOfRange, got %v", err)
+		}
+	}
+	if n, _ := p.GetInt(12); n != 0 {
+		t.Errorf("Expected rejected values not to be written, got %d", n)
+	}
+}
+
+func TestLengthHelpers(t *testing.T) {
+	// Given
+	ipos := 0
+	lpos := ipos + file.IntLength()
+	u16pos := lpos + file.Int64Length()
+	u32pos := u16pos + file.Uint16Length()
+	fpos := u32pos + file.Uint32Length()
+	bpos := fpos + file.Float64Length()
+	tpos := bpos + file.BoolLength()
+	bytespos := tpos + file.TimeLength()
+	p := file.NewPage(bytespos + file.BytesLength(3))
+
+	// When
+	errs := []error{
+		p.SetInt(ipos, 1),
+		p.SetInt64(lpos, 2),
+		p.SetUint16(u16pos, 3),
+		p.SetUint32(u32pos, 4),
+		p.SetFloat64(fpos, 5),
+		p.SetBool(bpos, true),
+		p.SetTime(tpos, time.Unix(6, 0)),
+		p.SetBytes(bytespos, []byte("abc")),
+	}
+
+	// Then
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("Expected values to fit the computed layout, got %v", err)
+		}
+	}
+}
+
+func TestMaxLength(t *testing.T) {
+	// Given
+	p := file.NewPage(file.MaxLength(3))
+
+	// When
+	p.SetString(0, "日本語")
+
+	// Then
+	if s, _ := p.GetString(0); s != "日本語" {
+		t.Errorf("Expected '日本語', got '%s'", s)
+	}
+}
+
+func TestSetBytesOverflow(t *testing.T) {
+	// Given
+	p := file.NewPage(16)
+
+	// When
+	fitErr := p.SetBytes(4, make([]byte, 8))
+	overflowErr := p.SetString(4, "123456789")
+	offsetErr := p.SetBytes(14, nil)
+
+	// Then
+	if fitErr != nil {
+		t.Errorf("Expected no error, got %v", fitErr)
+	}
+	if !errors.Is(overflowErr, file.ErrPageOverflow) {
+		t.Errorf("Expected ErrPageOverflow, got %v", overflowErr)
+	}
+	if !errors.Is(offsetErr, file.ErrOffsetOutOfRange) {
+		t.Errorf("Expected ErrOffsetOutOfRange, got %v", offsetErr)
+	}
+}
+
+func TestGetBytesWithCorruptLength(t *testing.T) {
+	// Given
+	p := file.NewPage(16)
+	p.SetInt(0, 13) // 残りは 12 バイトしかない
+
+	// When
+	_, err := p.GetBytes(0)
+
+	// Then
+	if !errors.Is(err, file.ErrPageOverflow) {
+		t.Errorf("Expected ErrPageOverflow, got %v", err)
+	}
+}
+
+func TestCopyFrom(t *testing.T) {
+	// Given
+	src := file.NewPage(64)
+	src.SetString(0, "hello")
+	src.SetLSN(7)
+	p := file.NewPage(64)
+
+	// When
+	p.CopyFrom(src)
+	src.SetString(0, "world")
+
+	// Then
+	if s, _ := p.GetString(0); s != "hello" {
+		t.Errorf("Expected 'hello', got '%s'", s)
+	}
+	if p.LSN() != 7 {
+		t.Errorf("Expected LSN 7, got %d", p.LSN())
+	}
+}
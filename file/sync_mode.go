@@ -0,0 +1,25 @@
+package file
+
+type SyncMode int
+
+const (
+	SYNC_NONE SyncMode = iota // OS のページキャッシュに任せる
+	SYNC_FSYNC // Sync のたびに fsync する
+	SYNC_FDATASYNC // Sync のたびに fdatasync する
+	SYNC_DSYNC // O_DSYNC で開き、書き込みごとに永続化する
+)
+
+func (m SyncMode) String() string {
+	switch m {
+	case SYNC_NONE:
+		return "none"
+	case SYNC_FSYNC:
+		return "fsync"
+	case SYNC_FDATASYNC:
+		return "fdatasync"
+	case SYNC_DSYNC:
+		return "dsync"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,101 @@
+package log
+
+import (
+	"time"
+
+	"github.com/nfphys/simpledb-go/file"
+)
+
+type groupCommit struct {
+	maxDelay time.Duration
+	maxBatch int
+	wake chan struct{} // Flush を待つ goroutine が来たことを flusher に知らせる
+	full chan struct{} // 待っている goroutine が maxBatch に達したことを知らせる
+	page *file.Page // 書き出し中のログページの写し
+	stop chan struct{}
+	done chan struct{}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// waitForFlush は flusher が lsn までを書き出すのを待つ。lm.mu を持って呼ぶこと。
+func (lm *LogMgr) waitForFlush(lsn int) error {
+	round := lm.flushRound
+	lm.waiters++
+	defer func() { lm.waiters-- }()
+
+	notify(lm.gc.wake)
+	if lm.waiters >= lm.gc.maxBatch {
+		notify(lm.gc.full)
+	}
+
+	for lsn > lm.lastSavedLSN {
+		if lm.closed {
+			return lm.flush()
+		}
+		if lm.flushRound > round && lm.flushErr != nil {
+			return lm.flushErr
+		}
+		lm.flushed.Wait()
+	}
+	return nil
+}
+
+// runFlusher は Close されるまで、待っている Flush をまとめて書き出す。
+func (lm *LogMgr) runFlusher() {
+	defer close(lm.gc.done)
+
+	for {
+		select {
+		case <-lm.gc.stop:
+			return
+		case <-lm.gc.wake:
+		}
+
+		timer := time.NewTimer(lm.gc.maxDelay)
+		select {
+		case <-lm.gc.stop:
+			timer.Stop()
+			return
+		case <-lm.gc.full:
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		lm.flushCopy()
+	}
+}
+
+// flushCopy はログページを写してから mu を外して書き出すので、
+// 書いている間も Append や LatestLSN は止まらない。
+// iomu を取ってから mu を外すので、後から flush したページより先に書かれる。
+func (lm *LogMgr) flushCopy() {
+	lm.mu.Lock()
+	if lm.latestLSN <= lm.lastSavedLSN {
+		lm.flushed.Broadcast()
+		lm.mu.Unlock()
+		return
+	}
+	blk := lm.blockId(lm.currentblk)
+	lsn := lm.latestLSN
+	lm.gc.page.CopyFrom(lm.logpage)
+	lm.iomu.Lock()
+	lm.mu.Unlock()
+
+	err := lm.writeLogPage(blk, lm.gc.page)
+	lm.iomu.Unlock()
+
+	lm.mu.Lock()
+	if err == nil {
+		lm.lastSavedLSN = max(lm.lastSavedLSN, lsn)
+	}
+	lm.flushErr = err
+	lm.flushRound++
+	lm.flushed.Broadcast()
+	lm.mu.Unlock()
+}
@@ -0,0 +1,213 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/nfphys/simpledb-go/file"
+)
+
+// Record はログレコードとその LSN の組。
+type Record struct {
+	LSN int
+	Data []byte
+}
+
+// Iterator は最新のレコードから順に読み出す。
+// 複数のブロックにまたがるレコードは組み立て直してから yield する。
+// 読み込みに失敗した場合はエラーを yield して終了する。
+func (lm *LogMgr) Iterator() func(func([]byte, error) bool) {
+	return dataOnly(lm.Records())
+}
+
+// ForwardIterator は最も古いレコードから順に読み出す。
+func (lm *LogMgr) ForwardIterator() func(func([]byte, error) bool) {
+	return dataOnly(lm.RecordsFrom(1))
+}
+
+// IteratorFrom は LSN が lsn 以上のレコードを古い順に読み出す。
+func (lm *LogMgr) IteratorFrom(lsn int) func(func([]byte, error) bool) {
+	return dataOnly(lm.RecordsFrom(lsn))
+}
+
+// Records は最新のレコードから順に、LSN と組にして読み出す。
+func (lm *LogMgr) Records() func(func(Record, error) bool) {
+	return func(yield func(Record, error) bool) {
+		first, last, err := lm.beginIteration()
+		if err != nil {
+			yield(Record{}, err)
+			return
+		}
+		defer lm.endIteration(first)
+
+		p := file.NewPage(lm.fm.BlockSize())
+		var pending []byte // 後ろのブロックから集めた、まだ先頭の断片が見つかっていないレコード
+
+		for n := last; n >= first; n-- {
+			lb, err := lm.readLogBlock(n, p)
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+
+			for i, rec := range lb.entries {
+				if i == 0 && lb.flags&CONT_OUT != 0 {
+					rec = append(rec, pending...)
+				}
+				if i == len(lb.entries)-1 && lb.flags&CONT_IN != 0 {
+					pending = rec
+					continue
+				}
+				pending = nil
+
+				if !yield(Record{LSN: lb.lsn - i, Data: rec}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// RecordsFrom は LSN が lsn 以上のレコードを古い順に、LSN と組にして読み出す。
+func (lm *LogMgr) RecordsFrom(lsn int) func(func(Record, error) bool) {
+	return func(yield func(Record, error) bool) {
+		first, last, err := lm.beginIteration()
+		if err != nil {
+			yield(Record{}, err)
+			return
+		}
+		defer lm.endIteration(first)
+
+		p := file.NewPage(lm.fm.BlockSize())
+		start, err := lm.findStartBlock(first, last, lsn, p)
+		if err != nil {
+			yield(Record{}, err)
+			return
+		}
+
+		var pending []byte // 前のブロックから続いている、まだ末尾の断片が見つかっていないレコード
+		pendingLSN := 0
+
+		for n := start; n <= last; n++ {
+			lb, err := lm.readLogBlock(n, p)
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+
+			for i := len(lb.entries) - 1; i >= 0; i-- {
+				rec, reclsn := lb.entries[i], lb.lsn-i
+				if i == len(lb.entries)-1 && lb.flags&CONT_IN != 0 {
+					if pending == nil {
+						continue // 先頭が読み始めより前にある断片
+					}
+					rec, reclsn = append(pending, rec...), pendingLSN
+				}
+				if i == 0 && lb.flags&CONT_OUT != 0 {
+					pending, pendingLSN = rec, reclsn
+					continue
+				}
+				pending = nil
+
+				if reclsn < lsn {
+					continue
+				}
+				if !yield(Record{LSN: reclsn, Data: rec}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// findStartBlock は last から first まで遡り、LSN が lsn 以下のレコードが始まるブロックを探す。
+func (lm *LogMgr) findStartBlock(first int, last int, lsn int, p *file.Page) (int, error) {
+	for n := last; n > first; n-- {
+		lb, err := lm.readLogBlock(n, p)
+		if err != nil {
+			return 0, err
+		}
+
+		oldest := lb.lsn - len(lb.entries) + 1 // ブロック内で最も古いレコードの LSN
+		if lb.flags&CONT_IN != 0 {
+			oldest++
+		}
+		if len(lb.entries) > 0 && oldest <= lsn {
+			return n, nil
+		}
+	}
+	return first, nil
+}
+
+// beginIteration はログを書き出し、残っている最初と最後のブロックの通し番号を返す。
+// endIteration を呼ぶまで、Truncate は first 以降のセグメントを消さない。
+func (lm *LogMgr) beginIteration() (int, int, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if err := lm.flush(); err != nil {
+		return 0, 0, fmt.Errorf("log: iterator: %w", err)
+	}
+	lm.readers[lm.firstblk]++
+	return lm.firstblk, lm.currentblk, nil
+}
+
+func (lm *LogMgr) endIteration(first int) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lm.readers[first]--; lm.readers[first] == 0 {
+		delete(lm.readers, first)
+	}
+}
+
+type logBlock struct {
+	entries [][]byte // 新しいものから順
+	lsn int // entries[0] の LSN
+	flags int
+}
+
+// readLogBlock は通し番号 n のブロックを読み、断片を新しいものから順にコピーして返す。
+func (lm *LogMgr) readLogBlock(n int, p *file.Page) (*logBlock, error) {
+	blk := lm.blockId(n)
+	if err := lm.fm.Read(blk, p); err != nil {
+		return nil, fmt.Errorf("log: iterator: %w", err)
+	}
+
+	blocksize := lm.fm.BlockSize()
+	pos := boundaryOf(p)
+	if !validHeader(p, blocksize) {
+		return nil, fmt.Errorf("log: iterator %v: %w: boundary %d, flags %d", blk, ErrCorruptLog, pos, flagsOf(p))
+	}
+	lsn, err := p.GetInt64(lsnPos)
+	if err != nil {
+		return nil, fmt.Errorf("log: iterator %v: %w", blk, err)
+	}
+
+	var entries [][]byte
+	for pos < blocksize {
+		n, ok := entryAt(p, pos, blocksize)
+		if !ok {
+			return nil, fmt.Errorf("log: iterator %v: %w: bad record at offset %d", blk, ErrCorruptLog, pos)
+		}
+		b, _ := p.GetBytes(pos)
+		entries = append(entries, bytes.Clone(b))
+		pos += n
+	}
+
+	return &logBlock{
+		entries: entries,
+		lsn: int(lsn),
+		flags: flagsOf(p),
+	}, nil
+}
+
+func dataOnly(records func(func(Record, error) bool)) func(func([]byte, error) bool) {
+	return func(yield func([]byte, error) bool) {
+		for rec, err := range records {
+			if !yield(rec.Data, err) || err != nil {
+				return
+			}
+		}
+	}
+}
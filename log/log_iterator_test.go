@@ -0,0 +1,164 @@
+package log_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nfphys/simpledb-go/log"
+)
+
+// appendRecords は長さの違うレコードを n 個追加する。ブロックサイズ 32 では
+// 途中のいくつかは複数のブロックにまたがる。
+func appendRecords(t *testing.T, lm *log.LogMgr, n int) []string {
+	recs := []string{}
+	for i := 1; i <= n; i++ {
+		rec := fmt.Sprintf("record%d", i) + strings.Repeat("!", (i%4)*10)
+		lsn, err := lm.Append([]byte(rec))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if lsn != i {
+			t.Fatalf("Expected LSN %d, got %d", i, lsn)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestForwardIterator(t *testing.T) {
+	// Given
+	fm := setup(t, 32)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := appendRecords(t, lm, 9)
+
+	// When
+	logs := []string{}
+	for rec, err := range lm.ForwardIterator() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		logs = append(logs, string(rec))
+	}
+
+	// Then
+	if strings.Join(logs, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, logs)
+	}
+}
+
+func TestIteratorFrom(t *testing.T) {
+	// Given
+	fm := setup(t, 32)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := appendRecords(t, lm, 9)
+
+	for from := 1; from <= 10; from++ {
+		// When
+		logs := []string{}
+		for rec, err := range lm.IteratorFrom(from) {
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			logs = append(logs, string(rec))
+		}
+
+		// Then
+		if strings.Join(logs, ",") != strings.Join(expected[from-1:], ",") {
+			t.Errorf("From %d: expected %v, got %v", from, expected[from-1:], logs)
+		}
+	}
+}
+
+func TestRecords(t *testing.T) {
+	// Given
+	fm := setup(t, 32)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := appendRecords(t, lm, 9)
+
+	// When
+	backward := []log.Record{}
+	for rec, err := range lm.Records() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		backward = append(backward, rec)
+	}
+	forward := []log.Record{}
+	for rec, err := range lm.RecordsFrom(4) {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		forward = append(forward, rec)
+	}
+
+	// Then
+	if len(backward) != len(expected) {
+		t.Fatalf("Expected %d records, got %d", len(expected), len(backward))
+	}
+	for i, rec := range backward {
+		lsn := len(expected) - i
+		if rec.LSN != lsn || string(rec.Data) != expected[lsn-1] {
+			t.Errorf("Expected (%d, %s), got (%d, %s)", lsn, expected[lsn-1], rec.LSN, rec.Data)
+		}
+	}
+	if len(forward) != len(expected)-3 {
+		t.Fatalf("Expected %d records, got %d", len(expected)-3, len(forward))
+	}
+	for i, rec := range forward {
+		lsn := i + 4
+		if rec.LSN != lsn || string(rec.Data) != expected[lsn-1] {
+			t.Errorf("Expected (%d, %s), got (%d, %s)", lsn, expected[lsn-1], rec.LSN, rec.Data)
+		}
+	}
+}
+
+func TestRecordsAfterReopen(t *testing.T) {
+	// Given
+	fm := setup(t, 32)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	appendRecords(t, lm, 3)
+	if err := lm.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	lm, err = log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lm.Append([]byte("record4"))
+
+	// When
+	lsns := []int{}
+	for rec, err := range lm.Records() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		lsns = append(lsns, rec.LSN)
+	}
+
+	// Then
+	if fmt.Sprint(lsns) != "[4 3 2 1]" {
+		t.Errorf("Expected [4 3 2 1], got %v", lsns)
+	}
+}
@@ -1,110 +1,348 @@
 package log
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/nfphys/simpledb-go/file"
 )
 
+const (
+	boundaryPos = 0 // 下位ビットに boundary、上位ビットにフラグを詰める
+	lsnPos = boundaryPos + file.INT_BYTES // ブロック内で最も新しいレコードの LSN
+	basePos = lsnPos + file.INT64_BYTES // このブロックより前に書き終わったレコードの LSN
+	headerBytes = basePos + file.INT64_BYTES
+	flagShift = 28
+	boundaryMask = 1<<flagShift - 1 // boundary はブロックサイズ以下なので下位 28 ビットに収まる
+)
+
+// 1 ブロックに収まらないレコードは断片に分けて連続するブロックに書く。
+// ブロックヘッダのフラグで、端の断片が前後のブロックに続いているかを表す。
+const (
+	CONT_IN = 1 << 0 // 最も古い断片は前のブロックの最も新しい断片の続き
+	CONT_OUT = 1 << 1 // 最も新しい断片は次のブロックに続く
+)
+
+var (
+	ErrClosed = errors.New("log manager closed")
+	ErrCorruptLog = errors.New("corrupt log")
+)
+
 type LogMgr struct {
 	fm *file.FileMgr
 	logfile string
-	logpage *file.Page // layout: [boundary(uint32)]...[rec3][rec2][rec1]
-	currentblk *file.BlockId
+	logpage *file.Page // layout: [flags<<28|boundary(uint32)][lsn(int64)][base(int64)]...[rec3][rec2][rec1]
+	segBlocks int // 0 ならセグメントに分けず logfile 1 つに書く
+	retention Retention
+	firstblk int // 残っている最も古いブロックの、セグメントをまたいだ通し番号
+	readers map[int]int // 動いているイテレータが読み始めたブロックと、そのイテレータの数
+	currentblk int
 	latestLSN int
 	lastSavedLSN int
+	dropped int // 開いたときに捨てた書きかけのレコードの数
+	gc *groupCommit // nil なら Flush はその場で書き出す
+	waiters int
+	flushRound int
+	flushErr error
+	flushed *sync.Cond
+	closed bool
 	mu sync.Mutex
+	iomu sync.Mutex // ログページを書き出す順序を守る。mu より後に取る
 }
 
-func NewLogMgr(fm *file.FileMgr, logfile string) *LogMgr {
-	logpage := file.NewPage(fm.BlockSize())
-
-	var currentblk *file.BlockId
-	if logsize := fm.Length(logfile); logsize == 0 {
-		currentblk = fm.Append(logfile)
-		logpage.SetInt(0, fm.BlockSize())
-		fm.Write(currentblk, logpage)
-	} else {
-		currentblk = file.NewBlockId(logfile, logsize-1)
-		fm.Read(currentblk, logpage)
+// NewLogMgr はログファイルを開く。既存のログであれば最後のブロックに記録された
+// LSN から採番を再開するので、再起動しても LSN は単調に増え続ける。
+// 最後のブロックが書きかけで壊れていれば、チェックサムの合うレコードまで切り詰める。
+// 捨てたレコードの数は DroppedRecords で分かる。
+func NewLogMgr(fm *file.FileMgr, logfile string, opts ...Option) (*LogMgr, error) {
+	if fm.BlockSize() <= headerBytes+entryOverhead {
+		return nil, fmt.Errorf("log: open %s: block size %d too small for log header: %w", logfile, fm.BlockSize(), file.ErrPageOverflow)
+	}
+	if fm.BlockSize() > boundaryMask {
+		return nil, fmt.Errorf("log: open %s: block size %d too large for log header: %w", logfile, fm.BlockSize(), file.ErrPageOverflow)
 	}
 
-	return &LogMgr{
+	lm := &LogMgr{
 		fm: fm,
 		logfile: logfile,
-		logpage: logpage,
-		currentblk: currentblk,
-		latestLSN: 0,
-		lastSavedLSN: 0,
+		logpage: file.NewPage(fm.BlockSize()),
+		readers: make(map[int]int),
 		mu: sync.Mutex{},
 	}
+	lm.flushed = sync.NewCond(&lm.mu)
+	for _, opt := range opts {
+		opt(lm)
+	}
+
+	first, last, err := lm.findBlocks()
+	if err != nil {
+		return nil, fmt.Errorf("log: open %s: %w", logfile, err)
+	}
+	lm.firstblk = first
+
+	if last < first {
+		lm.currentblk = first
+		if err := initLogPage(lm.logpage, fm.BlockSize(), 0, 0); err != nil {
+			return nil, fmt.Errorf("log: open %s: %w", logfile, err)
+		}
+		if err := fm.Write(lm.blockId(first), lm.logpage); err != nil {
+			return nil, fmt.Errorf("log: open %s: %w", logfile, err)
+		}
+	} else {
+		current, dropped, err := lm.recoverTail(first, last)
+		if err != nil {
+			return nil, fmt.Errorf("log: open %s: %w", logfile, err)
+		}
+		lm.currentblk = current
+		lm.dropped = dropped
+	}
+
+	lsn, err := lm.logpage.GetInt64(lsnPos)
+	if err != nil {
+		return nil, fmt.Errorf("log: open %s: %w", logfile, err)
+	}
+	lm.latestLSN = int(lsn)
+	lm.lastSavedLSN = int(lsn)
+
+	if lm.gc != nil {
+		lm.gc.page = file.NewPage(fm.BlockSize())
+		go lm.runFlusher()
+	}
+
+	return lm, nil
 }
 
-func (lm *LogMgr) Append(rec []byte) int {
+// Append はレコードをログページに追加して LSN を返す。
+// ページに収まらないレコードは、新しいブロックに収まればそこに書き、
+// 1 ブロックに収まらなければ断片に分けて複数のブロックにまたがって書く。
+func (lm *LogMgr) Append(rec []byte) (int, error) {
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
 
-	boundary := lm.logpage.GetInt(0)
+	if lm.closed {
+		return 0, fmt.Errorf("log: append: %w", ErrClosed)
+	}
+
+	boundary := boundaryOf(lm.logpage)
 	recsize := len(rec)
-	bytesneeded := recsize + file.INT_BYTES
+	bytesneeded := recsize + entryOverhead
+	lsn := lm.latestLSN + 1
 
-	if boundary - bytesneeded < file.INT_BYTES {
-		lm.flush()
-		lm.currentblk = lm.fm.Append(lm.logfile)
-		lm.logpage.SetInt(0, lm.fm.BlockSize())
-		lm.fm.Write(lm.currentblk, lm.logpage)
-		boundary = lm.logpage.GetInt(0)
+	if boundary - bytesneeded < headerBytes {
+		if bytesneeded > lm.fm.BlockSize()-headerBytes {
+			if err := lm.appendFragments(rec, lsn); err != nil {
+				return 0, fmt.Errorf("log: append: %w", err)
+			}
+			lm.latestLSN = lsn
+			return lm.latestLSN, nil
+		}
+
+		if err := lm.flush(); err != nil {
+			return 0, fmt.Errorf("log: append: %w", err)
+		}
+		if err := lm.appendNewBlock(0); err != nil {
+			return 0, fmt.Errorf("log: append: %w", err)
+		}
+		boundary = boundaryOf(lm.logpage)
 	}
 
 	recpos := boundary - bytesneeded
 
-	lm.logpage.SetBytes(recpos, rec)
-	lm.logpage.SetInt(0, recpos)
-	lm.latestLSN += 1
+	if err := lm.writeEntry(recpos, rec, lsn); err != nil {
+		return 0, fmt.Errorf("log: append: %w", err)
+	}
+	lm.latestLSN = lsn
+
+	return lm.latestLSN, nil
+}
+
+// appendFragments は rec を現在のブロックの空きから順に詰め、
+// 足りない分は新しいブロックに続けて書く。
+func (lm *LogMgr) appendFragments(rec []byte, lsn int) error {
+	for {
+		boundary := boundaryOf(lm.logpage)
+		n := min(len(rec), boundary-headerBytes-entryOverhead)
+		if n > 0 {
+			if err := lm.writeEntry(boundary-n-entryOverhead, rec[:n], lsn); err != nil {
+				return err
+			}
+			rec = rec[n:]
+		}
+		if len(rec) == 0 {
+			return nil
+		}
+
+		flags := 0
+		if n > 0 {
+			setFlags(lm.logpage, flagsOf(lm.logpage)|CONT_OUT)
+			flags = CONT_IN
+		}
+		if err := lm.flush(); err != nil {
+			return err
+		}
+		if err := lm.appendNewBlock(flags); err != nil {
+			return err
+		}
+	}
+}
+
+func (lm *LogMgr) writeEntry(pos int, b []byte, lsn int) error {
+	if err := lm.logpage.SetBytes(pos, b); err != nil {
+		return err
+	}
+	if err := lm.logpage.SetUint32(pos+file.INT_BYTES+len(b), entryChecksum(b)); err != nil {
+		return err
+	}
+	if err := lm.logpage.SetInt64(lsnPos, int64(lsn)); err != nil {
+		return err
+	}
+	setBoundary(lm.logpage, pos)
+	return nil
+}
+
+// LatestLSN は最後に Append したレコードの LSN を返す。
+func (lm *LogMgr) LatestLSN() int {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
 
 	return lm.latestLSN
 }
 
-func (lm *LogMgr) Flush(lsn int) {
+// DroppedRecords は NewLogMgr が書きかけの末尾から捨てたレコードの数を返す。
+func (lm *LogMgr) DroppedRecords() int {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	return lm.dropped
+}
+
+// FlushedLSN はディスクへの書き出しが済んでいるレコードの LSN の最大値を返す。
+func (lm *LogMgr) FlushedLSN() int {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	return lm.lastSavedLSN
+}
+
+// Flush は lsn までのレコードがディスクに書き出されるまで待つ。
+// group commit が有効なら、同時に待っている他の Flush とまとめて書き出される。
+func (lm *LogMgr) Flush(lsn int) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lsn = min(lsn, lm.latestLSN) // まだ Append されていない LSN は待たない
 	if lsn <= lm.lastSavedLSN {
-		return
+		return nil
 	}
 
-	lm.fm.Write(lm.currentblk, lm.logpage)
+	var err error
+	if lm.gc != nil && !lm.closed {
+		err = lm.waitForFlush(lsn)
+	} else {
+		err = lm.flush()
+	}
+	if err != nil {
+		return fmt.Errorf("log: flush: %w", err)
+	}
+	return nil
 }
 
-func (lm *LogMgr) Iterator() func(func([]byte) bool) {
-	lm.flush()
+// Close は flusher を止め、残っているレコードを書き出す。
+// FileMgr は閉じないので、呼び出し側で閉じること。
+func (lm *LogMgr) Close() error {
+	lm.mu.Lock()
+	if lm.closed {
+		lm.mu.Unlock()
+		return nil
+	}
+	lm.closed = true
+	lm.mu.Unlock()
 
-	return func(yield func([]byte) bool) {
-		p := file.NewPage(lm.fm.BlockSize())
+	if lm.gc != nil {
+		close(lm.gc.stop)
+		<-lm.gc.done
+	}
 
-		blk := lm.currentblk
-		lm.fm.Read(blk, p)
-		currentpos := p.GetInt(0)
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
 
-		for {
-			if currentpos == lm.fm.BlockSize() && blk.Number() == 0 {
-				break
-			}
+	err := lm.flush()
+	lm.flushed.Broadcast()
+	if err != nil {
+		return fmt.Errorf("log: close: %w", err)
+	}
+	return nil
+}
 
-			if currentpos == lm.fm.BlockSize() {
-				blk = file.NewBlockId(lm.logfile, blk.Number()-1)
-				lm.fm.Read(blk, p)
-				currentpos = p.GetInt(0)
-			}
+func (lm *LogMgr) flush() error {
+	lm.iomu.Lock()
+	defer lm.iomu.Unlock()
 
-			rec := p.GetBytes(currentpos)
-			currentpos += len(rec) + file.INT_BYTES
+	if err := lm.writeLogPage(lm.blockId(lm.currentblk), lm.logpage); err != nil {
+		return err
+	}
+	lm.lastSavedLSN = lm.latestLSN
+	return nil
+}
 
-			if !yield(rec) {
-				return
-			}
-		}
+// writeLogPage は p を blk に書いて Sync する。iomu を取って呼ぶこと。
+func (lm *LogMgr) writeLogPage(blk *file.BlockId, p *file.Page) error {
+	if err := lm.fm.Write(blk, p); err != nil {
+		return err
 	}
+	return lm.fm.Sync(blk.FileName())
 }
 
-func (lm *LogMgr) flush() {
-	lm.fm.Write(lm.currentblk, lm.logpage)
-	lm.lastSavedLSN = lm.latestLSN
+func (lm *LogMgr) appendNewBlock(flags int) error {
+	if err := initLogPage(lm.logpage, lm.fm.BlockSize(), lm.latestLSN, flags); err != nil {
+		return err
+	}
+	if err := lm.fm.Write(lm.blockId(lm.currentblk+1), lm.logpage); err != nil {
+		return err
+	}
+	lm.currentblk++
+	return nil
+}
+
+// initLogPage は空のログブロックのヘッダを書く。lsn は書き終わっている最新のレコードの LSN。
+func initLogPage(p *file.Page, blocksize int, lsn int, flags int) error {
+	if err := p.SetInt(boundaryPos, flags<<flagShift|blocksize); err != nil {
+		return err
+	}
+	if err := p.SetInt64(basePos, int64(lsn)); err != nil {
+		return err
+	}
+	return p.SetInt64(lsnPos, int64(lsn))
+}
+
+// boundaryOf はブロックで最も新しいレコードの位置を返す。
+func boundaryOf(p *file.Page) int {
+	return headerWord(p) & boundaryMask
+}
+
+// flagsOf はブロックの CONT_IN と CONT_OUT のフラグを返す。
+func flagsOf(p *file.Page) int {
+	return headerWord(p) >> flagShift
+}
+
+// headerWord は boundary とフラグを詰めたヘッダの語を返す。
+// ログのページはヘッダより大きいので、読むのに失敗することはない。
+func headerWord(p *file.Page) int {
+	w, _ := p.GetInt(boundaryPos)
+	return w
+}
+
+func setBoundary(p *file.Page, pos int) {
+	p.SetInt(boundaryPos, flagsOf(p)<<flagShift|pos)
+}
+
+func setFlags(p *file.Page, flags int) {
+	p.SetInt(boundaryPos, flags<<flagShift|boundaryOf(p))
+}
+
+// validHeader はヘッダの boundary とフラグがありうる値かを返す。
+func validHeader(p *file.Page, blocksize int) bool {
+	pos := boundaryOf(p)
+	return pos >= headerBytes && pos <= blocksize && flagsOf(p)&^(CONT_IN|CONT_OUT) == 0
 }
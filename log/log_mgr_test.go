@@ -1,40 +1,52 @@
 package log_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
-	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/nfphys/simpledb-go/file"
 	"github.com/nfphys/simpledb-go/log"
 )
 
-func setup(blocksize int) *file.FileMgr {
-	dbDir := filepath.Join(os.TempDir(), "testdb")
-	os.RemoveAll(dbDir)
-	return file.NewFileMgr(dbDir, blocksize)
+func setup(t *testing.T, blocksize int) *file.FileMgr {
+	fm, err := file.NewFileMgrWithStore(file.NewMemStore(), blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return fm
 }
 
 func cleanup(fm *file.FileMgr) {
 	fm.Close()
-	os.RemoveAll(filepath.Join(os.TempDir(), "testdb"))
 }
 
 func TestAppend(t *testing.T) {
 	// Given
-	blocksize := 32
-	fm := setup(blocksize)
+	blocksize := 54 // ヘッダ 20 バイト + 15 バイトのレコード 2 つ + 余り 4 バイト
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
-	lm := log.NewLogMgr(fm, "logfile")
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
 	// When
-	lsn1 := lm.Append([]byte("record1"))
-	lsn2 := lm.Append([]byte("record2"))
-	lsn3 := lm.Append([]byte("record3"))
-	lm.Flush(lsn3)
+	lsn1, _ := lm.Append([]byte("record1"))
+	lsn2, _ := lm.Append([]byte("record2"))
+	lsn3, _ := lm.Append([]byte("record3"))
+	err = lm.Flush(lsn3)
 
 	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	blk1 := file.NewBlockId("logfile", 0)
 	blk2 := file.NewBlockId("logfile", 1)
 	p1 := file.NewPage(blocksize)
@@ -51,30 +63,39 @@ func TestAppend(t *testing.T) {
 	if lsn3 != 3 {
 		t.Errorf("Expected 3, got %d", lsn3)
 	}
-	if p1.GetInt(0) != 10 {
-		t.Errorf("Expected boundary %d, got %d", 10, p1.GetInt(0))
+	if v, _ := p1.GetInt(0); v != 24  {
+		t.Errorf("Expected boundary %d, got %d", 24, v)
+	}
+	if v, _ := p2.GetInt(0); v != 39  {
+		t.Errorf("Expected boundary %d, got %d", 39, v)
+	}
+	if lsn, _ := p1.GetInt64(4); lsn != 2 {
+		t.Errorf("Expected block LSN %d, got %d", 2, lsn)
 	}
-	if p2.GetInt(0) != 21 {
-		t.Errorf("Expected boundary %d, got %d", 21, p2.GetInt(0))
+	if lsn, _ := p2.GetInt64(4); lsn != 3 {
+		t.Errorf("Expected block LSN %d, got %d", 3, lsn)
 	}
-	if p1.GetString(21) != "record1" {
-		t.Errorf("Expected 'record1', got '%s'", p1.GetString(21))
+	if s, _ := p1.GetString(39); s != "record1" {
+		t.Errorf("Expected 'record1', got '%s'", s)
 	}
-	if p1.GetString(10) != "record2" {
-		t.Errorf("Expected 'record2', got '%s'", p1.GetString(10))
+	if s, _ := p1.GetString(24); s != "record2" {
+		t.Errorf("Expected 'record2', got '%s'", s)
 	}
-	if p2.GetString(21) != "record3" {
-		t.Errorf("Expected 'record3', got '%s'", p2.GetString(21))
+	if s, _ := p2.GetString(39); s != "record3" {
+		t.Errorf("Expected 'record3', got '%s'", s)
 	}
 }
 
 func TestIterator(t *testing.T) {
 	// Given
 	blocksize := 32
-	fm := setup(blocksize)
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
-	lm := log.NewLogMgr(fm, "logfile")
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
 	lm.Append([]byte("record1"))
 	lm.Append([]byte("record2"))
@@ -83,7 +104,10 @@ func TestIterator(t *testing.T) {
 
 	// When
 	logs := []string{}
-	for rec := range lm.Iterator() {
+	for rec, err := range lm.Iterator() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
 		logs = append(logs, string(rec))
 	}
 
@@ -101,3 +125,486 @@ func TestIterator(t *testing.T) {
 		t.Errorf("Expected 'record1', got '%s'", logs[2])
 	}
 }
+
+type countingFile struct {
+	*os.File
+	syncs *int
+}
+
+func (f countingFile) Sync() error {
+	*f.syncs++
+	return f.File.Sync()
+}
+
+func TestFlushSyncsLogFile(t *testing.T) {
+	// Given
+	blocksize := 32
+	syncs := 0
+	openFile := func(name string, flag int, perm os.FileMode) (file.File, error) {
+		f, err := os.OpenFile(name, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		return countingFile{File: f, syncs: &syncs}, nil
+	}
+	fm, err := file.NewFileMgr(t.TempDir(), blocksize, file.WithSyncMode(file.SYNC_FSYNC), file.WithOpenFile(openFile))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lsn, _ := lm.Append([]byte("record1"))
+	syncs = 0
+
+	// When
+	err = lm.Flush(lsn)
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if syncs != 1 {
+		t.Errorf("Expected 1 sync, got %d", syncs)
+	}
+}
+
+func TestAppendRecordLargerThanBlock(t *testing.T) {
+	// Given
+	blocksize := 32
+	fm := setup(t, blocksize)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	large := make([]byte, 100)
+	for i := range large {
+		large[i] = byte(i)
+	}
+
+	// When
+	lm.Append([]byte("record1"))
+	lsn, err := lm.Append(large)
+	lm.Append([]byte("record3"))
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if lsn != 2 {
+		t.Errorf("Expected LSN 2, got %d", lsn)
+	}
+
+	recs := [][]byte{}
+	for rec, err := range lm.Iterator() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(recs))
+	}
+	if string(recs[0]) != "record3" {
+		t.Errorf("Expected 'record3', got '%s'", recs[0])
+	}
+	if !bytes.Equal(recs[1], large) {
+		t.Errorf("Expected large record to be reassembled, got %v", recs[1])
+	}
+	if string(recs[2]) != "record1" {
+		t.Errorf("Expected 'record1', got '%s'", recs[2])
+	}
+}
+
+func TestAppendRecordsSpanningBlocksBackToBack(t *testing.T) {
+	// Given
+	blocksize := 32
+	fm := setup(t, blocksize)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []string{}
+	for i := 0; i < 5; i++ {
+		rec := strings.Repeat(string(rune('a'+i)), 10+i*9)
+		if _, err := lm.Append([]byte(rec)); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		expected = append([]string{rec}, expected...)
+	}
+
+	// When
+	recs := []string{}
+	for rec, err := range lm.Iterator() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		recs = append(recs, string(rec))
+	}
+
+	// Then
+	if strings.Join(recs, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, recs)
+	}
+}
+
+func TestReopenResumesLSN(t *testing.T) {
+	// Given
+	blocksize := 32
+	store := file.NewMemStore()
+	fm, err := file.NewFileMgrWithStore(store, blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lm.Append([]byte("record1"))
+	lm.Append([]byte("record2"))
+	lsn, _ := lm.Append([]byte("record3"))
+	lm.Append([]byte("unflushed"))
+	if err := lm.Flush(lsn); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	fm.Close()
+
+	// When
+	fm, err = file.NewFileMgrWithStore(store, blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	lm, err = log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	next, err := lm.Append([]byte("record4"))
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if next != 4 {
+		t.Errorf("Expected LSN 4 after restart, got %d", next)
+	}
+	if lm.LatestLSN() != 4 {
+		t.Errorf("Expected latest LSN 4, got %d", lm.LatestLSN())
+	}
+	if lm.FlushedLSN() != 3 {
+		t.Errorf("Expected flushed LSN 3, got %d", lm.FlushedLSN())
+	}
+}
+
+type countingStore struct {
+	*file.MemStore
+	mu sync.Mutex
+	syncs int
+}
+
+func (s *countingStore) Sync(filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncs++
+	return s.MemStore.Sync(filename)
+}
+
+func (s *countingStore) Syncs() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncs
+}
+
+func TestFlushSkipsAlreadyFlushedLSN(t *testing.T) {
+	// Given
+	store := &countingStore{MemStore: file.NewMemStore()}
+	fm, err := file.NewFileMgrWithStore(store, 4096)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lsn1, _ := lm.Append([]byte("record1"))
+	lsn2, _ := lm.Append([]byte("record2"))
+	if err := lm.Flush(lsn2); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	before := store.Syncs()
+
+	// When
+	err = lm.Flush(lsn1)
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if store.Syncs() != before {
+		t.Errorf("Expected no additional sync, got %d", store.Syncs()-before)
+	}
+	if lm.FlushedLSN() != lsn2 {
+		t.Errorf("Expected flushed LSN %d, got %d", lsn2, lm.FlushedLSN())
+	}
+}
+
+func TestGroupCommit(t *testing.T) {
+	// Given
+	store := &countingStore{MemStore: file.NewMemStore()}
+	fm, err := file.NewFileMgrWithStore(store, 4096)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	n := 8
+	lm, err := log.NewLogMgr(fm, "logfile", log.WithGroupCommit(10*time.Second, n))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer lm.Close()
+	before := store.Syncs()
+
+	// When
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lsn, err := lm.Append([]byte("commit"))
+			if err == nil {
+				err = lm.Flush(lsn)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// Then
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if syncs := store.Syncs() - before; syncs != 1 {
+		t.Errorf("Expected %d flushes to share 1 sync, got %d", n, syncs)
+	}
+	if lm.FlushedLSN() != n {
+		t.Errorf("Expected flushed LSN %d, got %d", n, lm.FlushedLSN())
+	}
+}
+
+func TestGroupCommitMaxDelay(t *testing.T) {
+	// Given
+	fm := setup(t, 4096)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile", log.WithGroupCommit(time.Millisecond, 100))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer lm.Close()
+
+	// When
+	lsn, _ := lm.Append([]byte("commit"))
+	err = lm.Flush(lsn)
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if lm.FlushedLSN() != lsn {
+		t.Errorf("Expected flushed LSN %d, got %d", lsn, lm.FlushedLSN())
+	}
+}
+
+// blockingStore は block が閉じられるまで Sync を止める。
+type blockingStore struct {
+	*file.MemStore
+	entered chan struct{}
+	block chan struct{}
+}
+
+func (s *blockingStore) Sync(filename string) error {
+	select {
+	case s.entered <- struct{}{}:
+	default:
+	}
+	<-s.block
+	return s.MemStore.Sync(filename)
+}
+
+func TestGroupCommitAppendDuringSync(t *testing.T) {
+	// Given
+	store := &blockingStore{MemStore: file.NewMemStore(), entered: make(chan struct{}, 1), block: make(chan struct{})}
+	close(store.block) // NewLogMgr の Sync は止めない
+	fm, err := file.NewFileMgrWithStore(store, 4096)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+	lm, err := log.NewLogMgr(fm, "logfile", log.WithGroupCommit(time.Millisecond, 1))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer lm.Close()
+	<-store.entered
+	store.block = make(chan struct{})
+
+	lsn, _ := lm.Append([]byte("commit1"))
+	flushed := make(chan error, 1)
+	go func() { flushed <- lm.Flush(lsn) }()
+	<-store.entered // flusher が Sync の途中
+
+	// When
+	appended := make(chan int, 1)
+	go func() {
+		lsn, _ := lm.Append([]byte("commit2"))
+		appended <- lsn
+	}()
+
+	// Then
+	select {
+	case lsn := <-appended:
+		if lsn != 2 {
+			t.Errorf("Expected LSN 2, got %d", lsn)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected Append not to wait for the flusher's Sync")
+	}
+	close(store.block)
+	if err := <-flushed; err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if lm.FlushedLSN() < lsn {
+		t.Errorf("Expected flushed LSN at least %d, got %d", lsn, lm.FlushedLSN())
+	}
+}
+
+func TestGroupCommitFlushBeyondLatestLSN(t *testing.T) {
+	// Given
+	fm := setup(t, 4096)
+	defer cleanup(fm)
+	lm, err := log.NewLogMgr(fm, "logfile", log.WithGroupCommit(time.Millisecond, 100))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer lm.Close()
+	lm.Append([]byte("commit"))
+
+	// When
+	done := make(chan error, 1)
+	go func() { done <- lm.Flush(lm.LatestLSN() + 10) }()
+
+	// Then
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected Flush beyond the latest LSN to return")
+	}
+	if lm.FlushedLSN() != 1 {
+		t.Errorf("Expected flushed LSN 1, got %d", lm.FlushedLSN())
+	}
+}
+
+func benchmarkCommit(b *testing.B, opts ...log.Option) {
+	fm, err := file.NewFileMgr(b.TempDir(), 4096)
+	if err != nil {
+		b.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile", opts...)
+	if err != nil {
+		b.Fatalf("Expected no error, got %v", err)
+	}
+	defer lm.Close()
+
+	rec := make([]byte, 64)
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			lsn, err := lm.Append(rec)
+			if err == nil {
+				err = lm.Flush(lsn)
+			}
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkCommit(b *testing.B) {
+	benchmarkCommit(b)
+}
+
+func BenchmarkGroupCommit(b *testing.B) {
+	benchmarkCommit(b, log.WithGroupCommit(200*time.Microsecond, 64))
+}
+
+func TestMinimumBlockSize(t *testing.T) {
+	// ヘッダ 20 バイトとレコード 1 つ分の長さとチェックサム 8 バイトに、1 バイト以上の余りが要る
+	tests := []struct {
+		blocksize int
+		ok bool
+	}{
+		{28, false},
+		{29, true},
+		{32, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.blocksize), func(t *testing.T) {
+			// Given
+			fm := setup(t, tt.blocksize)
+			defer cleanup(fm)
+
+			// When
+			lm, err := log.NewLogMgr(fm, "logfile")
+
+			// Then
+			if !tt.ok {
+				if !errors.Is(err, file.ErrPageOverflow) {
+					t.Errorf("Expected ErrPageOverflow, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			rec := strings.Repeat("x", 50)
+			if _, err := lm.Append([]byte(rec)); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			for got, err := range lm.Iterator() {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if string(got) != rec {
+					t.Errorf("Expected %s, got %s", rec, got)
+				}
+			}
+		})
+	}
+}
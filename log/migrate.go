@@ -0,0 +1,93 @@
+package log
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/nfphys/simpledb-go/file"
+)
+
+// MigrateFormat1 は FORMAT_VERSION 1 のログを今の形式に書き換える Migration を返す。
+// file.WithMigration(1, log.MigrateFormat1(logfile)) のように登録する。
+// 1 のログはブロックごとに [boundary(uint32)]...[rec2][rec1] で、各レコードは [length][data]。
+// レコードは古い順に新しいログへ足し直すので、LSN は 1 から振り直される。
+func MigrateFormat1(logfile string) file.Migration {
+	return func(store file.BlockStore, h file.Header) error {
+		fm := file.NewMigrationFileMgr(store, h)
+		tmpfile := logfile + ".migrate"
+
+		length, err := fm.Length(logfile)
+		if err != nil {
+			return err
+		}
+		if length == 0 {
+			// 前回は古いログを消した後、書き直したログの名前を変える前に落ちた
+			tmplength, err := fm.Length(tmpfile)
+			if err != nil || tmplength == 0 {
+				return err
+			}
+			return store.Rename(tmpfile, logfile)
+		}
+
+		recs, err := readFormat1(fm, logfile, length)
+		if err != nil {
+			return err
+		}
+
+		if err := store.Remove(tmpfile); err != nil {
+			return err
+		}
+		lm, err := NewLogMgr(fm, tmpfile)
+		if err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			if _, err := lm.Append(rec); err != nil {
+				lm.Close()
+				return err
+			}
+		}
+		if err := lm.Close(); err != nil { // 書き直したログを Sync する
+			return err
+		}
+
+		if err := store.Remove(logfile); err != nil {
+			return err
+		}
+		return store.Rename(tmpfile, logfile)
+	}
+}
+
+// readFormat1 は FORMAT_VERSION 1 のログのレコードを古い順に返す。
+func readFormat1(fm *file.FileMgr, logfile string, length int) ([][]byte, error) {
+	blocksize := fm.BlockSize()
+	p := file.NewPage(blocksize)
+	recs := [][]byte{}
+
+	for n := range length {
+		blk := file.NewBlockId(logfile, n)
+		if err := fm.Read(blk, p); err != nil {
+			return nil, err
+		}
+		boundary, err := p.GetInt(boundaryPos)
+		if err != nil {
+			return nil, err
+		}
+		if boundary < file.INT_BYTES || boundary > blocksize {
+			return nil, fmt.Errorf("%v: %w: boundary %d out of range", blk, ErrCorruptLog, boundary)
+		}
+
+		blkrecs := [][]byte{}
+		for pos := boundary; pos < blocksize; {
+			rec, err := p.GetBytes(pos)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w: record at %d: %w", blk, ErrCorruptLog, pos, err)
+			}
+			blkrecs = append(blkrecs, slices.Clone(rec))
+			pos += file.BytesLength(len(rec))
+		}
+		slices.Reverse(blkrecs) // ブロックの中では新しいレコードが前にある
+		recs = append(recs, blkrecs...)
+	}
+	return recs, nil
+}
@@ -0,0 +1,88 @@
+package log_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/log"
+)
+
+// writeFormat1 は FORMAT_VERSION 1 の形式でログを書く。blocks はブロックごとのレコードを古い順に並べたもの。
+func writeFormat1(t *testing.T, store file.BlockStore, blocksize int, blocks [][]string) {
+	for n, recs := range blocks {
+		b := make([]byte, blocksize)
+		p := file.NewPageFromBytes(b)
+		boundary := blocksize
+		for _, rec := range recs {
+			boundary -= file.BytesLength(len(rec))
+			if err := p.SetString(boundary, rec); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+		}
+		if err := p.SetInt(0, boundary); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := store.WriteAt("logfile", b, int64(n*blocksize)); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+}
+
+func TestMigrateFormat1(t *testing.T) {
+	// Given
+	blocksize := 40
+	store := file.NewMemStore()
+	h := file.Header{Version: 1, BlockSize: blocksize, LittleEndian: true, CreatedAt: time.Now()}
+	if err := file.WriteHeader(store, h); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	writeFormat1(t, store, blocksize, [][]string{{"record1", "record2"}, {"record3"}})
+
+	// When
+	fm, err := file.NewFileMgrWithStore(store, blocksize, file.WithMigration(1, log.MigrateFormat1("logfile")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Then
+	if fm.Header().Version != file.FORMAT_VERSION {
+		t.Errorf("Expected version %d, got %d", file.FORMAT_VERSION, fm.Header().Version)
+	}
+	if lm.LatestLSN() != 3 {
+		t.Errorf("Expected LSN 3, got %d", lm.LatestLSN())
+	}
+	if recs := fmt.Sprint(collectRecords(t, lm)); recs != "[record3 record2 record1]" {
+		t.Errorf("Expected [record3 record2 record1], got %s", recs)
+	}
+	if files, _ := store.List(); fmt.Sprint(files) != "[logfile simpledb.ctl]" {
+		t.Errorf("Expected [logfile simpledb.ctl], got %v", files)
+	}
+}
+
+func TestMigrateFormat1WithoutLog(t *testing.T) {
+	// Given
+	store := file.NewMemStore()
+	h := file.Header{Version: 1, BlockSize: 40, LittleEndian: true, CreatedAt: time.Now()}
+	if err := file.WriteHeader(store, h); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	fm, err := file.NewFileMgrWithStore(store, 40, file.WithMigration(1, log.MigrateFormat1("logfile")))
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+	if length, _ := fm.Length("logfile"); length != 0 {
+		t.Errorf("Expected empty log, got %d blocks", length)
+	}
+}
@@ -0,0 +1,33 @@
+package log
+
+import (
+	"time"
+)
+
+type Option func(*LogMgr)
+
+// WithGroupCommit は Flush をバックグラウンドの flusher goroutine にまとめさせる。
+// 最初の Flush から maxDelay 経つか、待っている Flush が maxBatch 個に達した時点で
+// 1 回の書き込みと Sync で全員の LSN をディスクに書き出す。
+func WithGroupCommit(maxDelay time.Duration, maxBatch int) Option {
+	return func(lm *LogMgr) {
+		lm.gc = &groupCommit{
+			maxDelay: maxDelay,
+			maxBatch: maxBatch,
+			wake: make(chan struct{}, 1),
+			full: make(chan struct{}, 1),
+			stop: make(chan struct{}),
+			done: make(chan struct{}),
+		}
+	}
+}
+
+// WithSegments はログを blocks ブロックずつのセグメントファイル
+// (logfile.000000, logfile.000001, ...) に分けて書く。
+// Truncate で古いセグメントを retention に従って捨てられるようになる。
+func WithSegments(blocks int, retention Retention) Option {
+	return func(lm *LogMgr) {
+		lm.segBlocks = blocks
+		lm.retention = retention
+	}
+}
@@ -0,0 +1,160 @@
+package log
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/nfphys/simpledb-go/file"
+)
+
+// Retention はチェックポイントで不要になったセグメントの扱いを決める。
+type Retention struct {
+	KeepSegments int // 不要になっても残しておくセグメントの数
+	ArchiveDir string // 空でなければ削除せずにこのディレクトリへ移す
+}
+
+// Usage はログが使っているディスク容量。
+type Usage struct {
+	Segments int
+	Bytes int64
+}
+
+func (lm *LogMgr) segmentName(seg int) string {
+	return fmt.Sprintf("%s.%06d", lm.logfile, seg)
+}
+
+// blockId はセグメントをまたいだ通し番号 n のブロックの場所を返す。
+func (lm *LogMgr) blockId(n int) *file.BlockId {
+	if lm.segBlocks == 0 {
+		return file.NewBlockId(lm.logfile, n)
+	}
+	return file.NewBlockId(lm.segmentName(n/lm.segBlocks), n%lm.segBlocks)
+}
+
+// findBlocks は残っている最初と最後のブロックの通し番号を返す。
+// ログが空なら last は first より小さい。
+func (lm *LogMgr) findBlocks() (first int, last int, err error) {
+	if lm.segBlocks == 0 {
+		length, err := lm.fm.Length(lm.logfile)
+		if err != nil {
+			return 0, 0, err
+		}
+		return 0, length-1, nil
+	}
+
+	segs, err := lm.segments()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(segs) == 0 {
+		return 0, -1, nil
+	}
+
+	lastSeg := segs[len(segs)-1]
+	length, err := lm.fm.Length(lm.segmentName(lastSeg))
+	if err != nil {
+		return 0, 0, err
+	}
+	return segs[0]*lm.segBlocks, lastSeg*lm.segBlocks + length - 1, nil
+}
+
+// segments は残っているセグメントの番号を昇順に返す。
+func (lm *LogMgr) segments() ([]int, error) {
+	names, err := lm.fm.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	var segs []int
+	for _, name := range names {
+		suffix, ok := strings.CutPrefix(name, lm.logfile+".")
+		if !ok {
+			continue
+		}
+		var seg int
+		if _, err := fmt.Sscanf(suffix, "%d", &seg); err != nil || lm.segmentName(seg) != name {
+			continue
+		}
+		segs = append(segs, seg)
+	}
+	return segs, nil
+}
+
+// Truncate は LSN が lsn より小さいレコードしか含まないセグメントを、
+// Retention に従って削除またはアーカイブし、その数を返す。
+// チェックポイントの後、その LSN を渡して呼ぶ。書き込み中のセグメントと、
+// 動いているイテレータが読んでいるセグメントは残し、次の Truncate で消す。
+func (lm *LogMgr) Truncate(lsn int) (int, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lm.segBlocks == 0 {
+		return 0, nil
+	}
+
+	firstSeg := lm.firstblk / lm.segBlocks
+	currentSeg := lm.currentblk / lm.segBlocks
+	p := file.NewPage(lm.fm.BlockSize())
+
+	n := 0
+	for seg := firstSeg; seg < currentSeg; seg++ {
+		if err := lm.fm.Read(lm.blockId((seg+1)*lm.segBlocks-1), p); err != nil {
+			return 0, fmt.Errorf("log: truncate: %w", err)
+		}
+		newest, err := p.GetInt64(lsnPos)
+		if err != nil {
+			return 0, fmt.Errorf("log: truncate: %w", err)
+		}
+		if int(newest) >= lsn {
+			break
+		}
+		n++
+	}
+	n = max(n-lm.retention.KeepSegments, 0)
+	for first := range lm.readers {
+		n = min(n, first/lm.segBlocks-firstSeg)
+	}
+
+	for seg := firstSeg; seg < firstSeg+n; seg++ {
+		name := lm.segmentName(seg)
+		var err error
+		if lm.retention.ArchiveDir != "" {
+			err = lm.fm.Rename(name, path.Join(lm.retention.ArchiveDir, name))
+		} else {
+			err = lm.fm.Remove(name)
+		}
+		if err != nil {
+			return seg - firstSeg, fmt.Errorf("log: truncate: %w", err)
+		}
+		lm.firstblk = (seg + 1) * lm.segBlocks
+	}
+
+	return n, nil
+}
+
+// DiskUsage は残っているログのセグメント数とバイト数を返す。
+// アーカイブに移したセグメントは含まない。
+func (lm *LogMgr) DiskUsage() (Usage, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	names := []string{lm.logfile}
+	if lm.segBlocks > 0 {
+		names = nil
+		for seg := lm.firstblk / lm.segBlocks; seg <= lm.currentblk/lm.segBlocks; seg++ {
+			names = append(names, lm.segmentName(seg))
+		}
+	}
+
+	usage := Usage{}
+	for _, name := range names {
+		size, err := lm.fm.FileSize(name)
+		if err != nil {
+			return Usage{}, fmt.Errorf("log: disk usage: %w", err)
+		}
+		usage.Segments++
+		usage.Bytes += size
+	}
+	return usage, nil
+}
@@ -0,0 +1,222 @@
+package log_test
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/log"
+)
+
+func collectLSNs(t *testing.T, lm *log.LogMgr) []int {
+	lsns := []int{}
+	for rec, err := range lm.Records() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		lsns = append(lsns, rec.LSN)
+	}
+	return lsns
+}
+
+func TestSegments(t *testing.T) {
+	// Given
+	store := file.NewMemStore()
+	fm, err := file.NewFileMgrWithStore(store, 40)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile", log.WithSegments(2, log.Retention{}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	for i := 1; i <= 5; i++ {
+		lm.Append([]byte(fmt.Sprintf("record%d", i))) // 1 ブロックに 1 レコード
+	}
+	lsns := collectLSNs(t, lm)
+
+	// Then
+	if fmt.Sprint(lsns) != "[5 4 3 2 1]" {
+		t.Errorf("Expected [5 4 3 2 1], got %v", lsns)
+	}
+	names, _ := store.List()
+	for _, name := range []string{"logfile.000000", "logfile.000001", "logfile.000002"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("Expected segment %s, got %v", name, names)
+		}
+	}
+	if slices.Contains(names, "logfile") {
+		t.Errorf("Expected no unsegmented logfile, got %v", names)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		retention log.Retention
+		expectedRemoved int
+		expectedLSNs string
+		expectedArchived []string
+	}{
+		{"delete", log.Retention{}, 2, "[7 6 5]", nil},
+		{"keep one", log.Retention{KeepSegments: 1}, 1, "[7 6 5 4 3]", nil},
+		{"archive", log.Retention{ArchiveDir: "archive"}, 2, "[7 6 5]", []string{"archive/logfile.000000", "archive/logfile.000001"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			store := file.NewMemStore()
+			fm, err := file.NewFileMgrWithStore(store, 40)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			defer cleanup(fm)
+
+			lm, err := log.NewLogMgr(fm, "logfile", log.WithSegments(2, tt.retention))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			for i := 1; i <= 7; i++ {
+				lm.Append([]byte(fmt.Sprintf("record%d", i)))
+			}
+			lm.Flush(7)
+			before, _ := lm.DiskUsage()
+
+			// When
+			removed, err := lm.Truncate(5) // LSN 5 のチェックポイントを想定
+
+			// Then
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if removed != tt.expectedRemoved {
+				t.Errorf("Expected %d segments removed, got %d", tt.expectedRemoved, removed)
+			}
+			if lsns := fmt.Sprint(collectLSNs(t, lm)); lsns != tt.expectedLSNs {
+				t.Errorf("Expected %s, got %s", tt.expectedLSNs, lsns)
+			}
+			after, _ := lm.DiskUsage()
+			if after.Segments != before.Segments-removed {
+				t.Errorf("Expected %d segments, got %d", before.Segments-removed, after.Segments)
+			}
+			if after.Bytes != before.Bytes-int64(removed*2*40) {
+				t.Errorf("Expected %d bytes, got %d", before.Bytes-int64(removed*2*40), after.Bytes)
+			}
+			names, _ := store.List()
+			for _, name := range tt.expectedArchived {
+				if !slices.Contains(names, name) {
+					t.Errorf("Expected archived segment %s, got %v", name, names)
+				}
+			}
+		})
+	}
+}
+
+func TestReopenAfterTruncate(t *testing.T) {
+	// Given
+	fm := setup(t, 40)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile", log.WithSegments(2, log.Retention{}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for i := 1; i <= 7; i++ {
+		lm.Append([]byte(fmt.Sprintf("record%d", i)))
+	}
+	if _, err := lm.Truncate(5); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lm.Close()
+
+	// When
+	lm, err = log.NewLogMgr(fm, "logfile", log.WithSegments(2, log.Retention{}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lsn, _ := lm.Append([]byte("record8"))
+
+	// Then
+	if lsn != 8 {
+		t.Errorf("Expected LSN 8, got %d", lsn)
+	}
+	if lsns := fmt.Sprint(collectLSNs(t, lm)); lsns != "[8 7 6 5]" {
+		t.Errorf("Expected [8 7 6 5], got %s", lsns)
+	}
+	recs := []string{}
+	for rec, err := range lm.IteratorFrom(1) {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		recs = append(recs, string(rec))
+	}
+	if fmt.Sprint(recs) != "[record5 record6 record7 record8]" {
+		t.Errorf("Expected [record5 record6 record7 record8], got %v", recs)
+	}
+}
+
+func TestTruncateDuringIteration(t *testing.T) {
+	// Given
+	dbDir := t.TempDir()
+	fm, err := file.NewFileMgr(dbDir, 40)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile", log.WithSegments(2, log.Retention{}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for i := 1; i <= 40; i++ {
+		lm.Append([]byte(fmt.Sprintf("record%d", i)))
+	}
+
+	// When
+	count := 0
+	for _, err := range lm.ForwardIterator() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		count++
+		if count == 1 {
+			removed, err := lm.Truncate(40)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if removed != 0 {
+				t.Errorf("Expected no segments removed while iterating, got %d", removed)
+			}
+		}
+	}
+	removed, err := lm.Truncate(40)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lm.Close()
+
+	// Then
+	if count != 40 {
+		t.Errorf("Expected 40 records, got %d", count)
+	}
+	if removed != 19 {
+		t.Errorf("Expected 19 segments removed after iterating, got %d", removed)
+	}
+	lm, err = log.NewLogMgr(fm, "logfile", log.WithSegments(2, log.Retention{}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	usage, _ := lm.DiskUsage()
+	if usage.Segments != 1 {
+		t.Errorf("Expected 1 segment after reopen, got %d", usage.Segments)
+	}
+	if lsns := fmt.Sprint(collectLSNs(t, lm)); lsns != "[40 39]" {
+		t.Errorf("Expected [40 39], got %s", lsns)
+	}
+}
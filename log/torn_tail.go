@@ -0,0 +1,170 @@
+package log
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/nfphys/simpledb-go/file"
+)
+
+// 各レコードは [length(uint32)][data][crc32c(uint32)] の形でブロックに書く。
+// チェックサムは length と data から計算する。
+const entryOverhead = file.INT_BYTES + file.UINT32_BYTES
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func entryChecksum(b []byte) uint32 {
+	var lb [file.INT_BYTES]byte
+	binary.LittleEndian.PutUint32(lb[:], uint32(len(b)))
+	return crc32.Update(crc32.Checksum(lb[:], castagnoli), castagnoli, b)
+}
+
+// entryAt は pos から始まるレコードのブロック上のバイト数を返す。
+// 長さがブロックをはみ出すか、チェックサムが合わなければ ok は false。
+func entryAt(p *file.Page, pos int, blocksize int) (n int, ok bool) {
+	if pos+entryOverhead > blocksize {
+		return 0, false
+	}
+	b, err := p.GetBytes(pos)
+	if err != nil {
+		return 0, false
+	}
+	n = len(b) + entryOverhead
+	if pos+n > blocksize {
+		return 0, false
+	}
+	stored, err := p.GetUint32(pos + file.INT_BYTES + len(b))
+	if err != nil || stored != entryChecksum(b) {
+		return 0, false
+	}
+	return n, true
+}
+
+// countEntries は pos からブロックの終わりまでのレコードを数える。
+// 途中に壊れたレコードがあるか、ちょうど終わりで切れなければ ok は false。
+func countEntries(p *file.Page, pos int, blocksize int) (count int, ok bool) {
+	for pos < blocksize {
+		n, ok := entryAt(p, pos, blocksize)
+		if !ok {
+			return 0, false
+		}
+		pos += n
+		count++
+	}
+	return count, pos == blocksize
+}
+
+// validBoundary はブロックの終わりまで壊れずに続いているレコードの先頭を返す。
+// boundary から読めなければ、書きかけの新しいレコードを飛ばして古い側から探す。
+func validBoundary(p *file.Page, boundary int, blocksize int) (int, int) {
+	for pos := boundary; pos < blocksize; pos++ {
+		if count, ok := countEntries(p, pos, blocksize); ok {
+			return pos, count
+		}
+	}
+	return blocksize, 0
+}
+
+// recoverTail は書き込みの途中で落ちたログの末尾を切り詰め、
+// 書き込みを続けるブロックの通し番号と、捨てたレコードの数を返す。
+// ヘッダが読めないブロックや、前のブロックからの続きを失ったブロックは丸ごと捨てて
+// ゼロで上書きし、次に開いたときにも末尾とみなされないようにする。
+// 上書きするのは読めるブロックが見つかってからで、ログがこの形式でなければ何も書かずに
+// ErrCorruptLog を返す。
+func (lm *LogMgr) recoverTail(first int, last int) (int, int, error) {
+	blocksize := lm.fm.BlockSize()
+	p := lm.logpage
+	claimed := -1 // 読めたヘッダのうち最も新しい LSN
+	var discarded []*file.BlockId
+
+	for n := last; n >= first; n-- {
+		blk := lm.blockId(n)
+		err := lm.fm.Read(blk, p)
+		if err != nil && !errors.Is(err, file.ErrCorruptBlock) {
+			return 0, 0, err
+		}
+
+		boundary := boundaryOf(p)
+		if err != nil || !validHeader(p, blocksize) {
+			// ページのチェックサムが合わないブロックや、ヘッダまで届かなかったブロック
+			if n == first {
+				if n > 0 {
+					return 0, 0, fmt.Errorf("%v: %w: no readable log block", blk, ErrCorruptLog)
+				}
+				if err != nil || !p.IsZero() {
+					// 古い形式のログや壊れたログを空のログで上書きしない
+					return 0, 0, fmt.Errorf("%v: %w: unrecognized log header", blk, ErrCorruptLog)
+				}
+				// 最初のブロックを書いた直後に落ちた
+				if err := initLogPage(p, blocksize, 0, 0); err != nil {
+					return 0, 0, err
+				}
+				return n, 0, lm.recovered(blk, discarded)
+			}
+			discarded = append(discarded, blk)
+			continue
+		}
+
+		lsn, err := p.GetInt64(lsnPos)
+		if err != nil {
+			return 0, 0, err
+		}
+		base, err := p.GetInt64(basePos)
+		if err != nil {
+			return 0, 0, err
+		}
+		if claimed < 0 {
+			claimed = int(lsn)
+		}
+		flags := flagsOf(p)
+
+		pos, count := validBoundary(p, boundary, blocksize)
+		if flags&CONT_OUT != 0 {
+			// 続きの断片は後ろのブロックと一緒に失われているので、最も新しい断片も捨てる
+			if pos == boundary && count > 0 {
+				size, _ := entryAt(p, pos, blocksize)
+				pos += size
+				count--
+			}
+			flags &^= CONT_OUT
+		}
+		if count == 0 && flags&CONT_IN != 0 {
+			if n > first {
+				// 前のブロックから続くレコードの断片が残っていない
+				discarded = append(discarded, blk)
+				continue
+			}
+			flags &^= CONT_IN
+		}
+
+		latest := int(base) + count
+		if pos == boundary && latest == int(lsn) && flags == flagsOf(p) && n == last {
+			return n, 0, nil
+		}
+
+		if err := p.SetInt(boundaryPos, flags<<flagShift|pos); err != nil {
+			return 0, 0, err
+		}
+		if err := p.SetInt64(lsnPos, int64(latest)); err != nil {
+			return 0, 0, err
+		}
+		return n, max(claimed-latest, 0), lm.recovered(blk, discarded)
+	}
+
+	return 0, 0, fmt.Errorf("%w: no readable log block", ErrCorruptLog)
+}
+
+// recovered は捨てたブロックをゼロで上書きし、切り詰めた logpage を blk に書く。
+func (lm *LogMgr) recovered(blk *file.BlockId, discarded []*file.BlockId) error {
+	for _, d := range discarded {
+		if err := lm.fm.Write(d, file.NewPage(lm.fm.BlockSize())); err != nil {
+			return err
+		}
+	}
+	if err := lm.fm.Write(blk, lm.logpage); err != nil {
+		return err
+	}
+	return lm.fm.Sync(blk.FileName())
+}
@@ -0,0 +1,212 @@
+package log_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/log"
+)
+
+func collectRecords(t *testing.T, lm *log.LogMgr) []string {
+	recs := []string{}
+	for rec, err := range lm.Iterator() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		recs = append(recs, string(rec))
+	}
+	return recs
+}
+
+func reopen(t *testing.T, store file.BlockStore, fm *file.FileMgr, blocksize int) (*file.FileMgr, *log.LogMgr) {
+	fm.Close()
+	fm, err := file.NewFileMgrWithStore(store, blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return fm, lm
+}
+
+func TestReopenDropsNothing(t *testing.T) {
+	// Given
+	store := file.NewMemStore()
+	fm, err := file.NewFileMgrWithStore(store, 40)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	appendRecords(t, lm, 5)
+	lm.Close()
+
+	// When
+	fm, lm = reopen(t, store, fm, 40)
+	defer cleanup(fm)
+
+	// Then
+	if lm.DroppedRecords() != 0 {
+		t.Errorf("Expected 0 dropped records, got %d", lm.DroppedRecords())
+	}
+	if lm.LatestLSN() != 5 {
+		t.Errorf("Expected LSN 5, got %d", lm.LatestLSN())
+	}
+}
+
+func TestTornRecordIsDropped(t *testing.T) {
+	// Given
+	blocksize := 400
+	store := file.NewMemStore()
+	fm, err := file.NewFileMgrWithStore(store, blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lm.Append([]byte("record1"))
+	lm.Append([]byte("record2"))
+	lm.Append([]byte("record3"))
+	lm.Close()
+
+	// record3 のデータだけがディスクに届かなかった状態を作る
+	p := file.NewPage(blocksize)
+	fm.Read(file.NewBlockId("logfile", 0), p)
+	boundary, _ := p.GetInt(0)
+	store.WriteAt("logfile", []byte("garbage"), int64(boundary+file.INT_BYTES))
+
+	// When
+	fm, lm = reopen(t, store, fm, blocksize)
+	defer cleanup(fm)
+	lsn, _ := lm.Append([]byte("record4"))
+
+	// Then
+	if lm.DroppedRecords() != 1 {
+		t.Errorf("Expected 1 dropped record, got %d", lm.DroppedRecords())
+	}
+	if lsn != 3 {
+		t.Errorf("Expected LSN 3, got %d", lsn)
+	}
+	if recs := fmt.Sprint(collectRecords(t, lm)); recs != "[record4 record2 record1]" {
+		t.Errorf("Expected [record4 record2 record1], got %s", recs)
+	}
+}
+
+func TestTornFragmentDropsWholeRecord(t *testing.T) {
+	// Given
+	blocksize := 40
+	store := file.NewMemStore()
+	fm, err := file.NewFileMgrWithStore(store, blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lm.Append([]byte("record1"))
+	lm.Append(make([]byte, 100))
+	lm.Close()
+
+	// 最後の断片を書いたブロックが丸ごと失われた状態を作る
+	length, _ := fm.Length("logfile")
+	store.WriteAt("logfile", make([]byte, blocksize), int64((length-1)*blocksize))
+
+	// When
+	fm, lm = reopen(t, store, fm, blocksize)
+	defer cleanup(fm)
+
+	// Then
+	if lm.DroppedRecords() != 1 {
+		t.Errorf("Expected 1 dropped record, got %d", lm.DroppedRecords())
+	}
+	if recs := fmt.Sprint(collectRecords(t, lm)); recs != "[record1]" {
+		t.Errorf("Expected [record1], got %s", recs)
+	}
+
+	// 捨てた断片は再び開いても読まれない
+	lm.Append([]byte("record2"))
+	lm.Close()
+	fm, lm = reopen(t, store, fm, blocksize)
+	defer cleanup(fm)
+	if lm.DroppedRecords() != 0 {
+		t.Errorf("Expected 0 dropped records, got %d", lm.DroppedRecords())
+	}
+	if recs := fmt.Sprint(collectRecords(t, lm)); recs != "[record2 record1]" {
+		t.Errorf("Expected [record2 record1], got %s", recs)
+	}
+}
+
+func TestIteratorReportsCorruptRecord(t *testing.T) {
+	// Given
+	blocksize := 40
+	store := file.NewMemStore()
+	fm, err := file.NewFileMgrWithStore(store, blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lm.Append([]byte("record1"))
+	lm.Append([]byte("record2"))
+	lm.Flush(2)
+
+	// 末尾ではないブロックのレコードを壊す
+	store.WriteAt("logfile", []byte("garbage"), int64(blocksize-10))
+
+	// When
+	var iterErr error
+	for _, err := range lm.Iterator() {
+		if err != nil {
+			iterErr = err
+		}
+	}
+
+	// Then
+	if !errors.Is(iterErr, log.ErrCorruptLog) {
+		t.Errorf("Expected ErrCorruptLog, got %v", iterErr)
+	}
+}
+
+func TestUnrecognizedLogIsNotReinitialized(t *testing.T) {
+	// Given
+	blocksize := 40
+	store := file.NewMemStore()
+	fm, err := file.NewFileMgrWithStore(store, blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+	// 古い形式のログ: [boundary][len][data] のブロックが 2 つ
+	writeFormat1(t, store, blocksize, [][]string{{"record1", "record2"}, {"record3", "record4"}})
+	before, _ := store.Size("logfile")
+
+	// When
+	_, err = log.NewLogMgr(fm, "logfile")
+
+	// Then
+	if !errors.Is(err, log.ErrCorruptLog) {
+		t.Errorf("Expected ErrCorruptLog, got %v", err)
+	}
+	p := file.NewPage(blocksize)
+	if err := fm.Read(file.NewBlockId("logfile", 1), p); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if p.IsZero() {
+		t.Errorf("Expected block 1 to be left as is, got zeroed block")
+	}
+	if after, _ := store.Size("logfile"); after != before {
+		t.Errorf("Expected log size %d, got %d", before, after)
+	}
+}
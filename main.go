@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/nfphys/simpledb-go/file"
@@ -8,24 +9,42 @@ import (
 )
 
 func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
 	dbDir := "testdb"
 	blocksize := 32
 
-	fm := file.NewFileMgr(dbDir, blocksize)
+	logfile := "logfile"
+
+	fm, err := file.NewFileMgr(dbDir, blocksize, file.WithMigration(1, log.MigrateFormat1(logfile)))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dbDir)
 	defer fm.Close()
 
-	logfile := "logfile"
-	lm := log.NewLogMgr(fm, logfile)
+	lm, err := log.NewLogMgr(fm, logfile)
+	if err != nil {
+		return err
+	}
 
-	lm.Append([]byte("record1"))
-	lm.Append([]byte("record2"))
-	lm.Append([]byte("record3"))
-	lm.Append([]byte("record4"))
-	lm.Append([]byte("record5"))
+	for _, rec := range []string{"record1", "record2", "record3", "record4", "record5"} {
+		if _, err := lm.Append([]byte(rec)); err != nil {
+			return err
+		}
+	}
 
-	for rec := range lm.Iterator() {
+	for rec, err := range lm.Iterator() {
+		if err != nil {
+			return err
+		}
 		println(string(rec))
 	}
 
-	os.RemoveAll("testdb")
+	return nil
 }
@@ -1,6 +1,8 @@
 package tx
 
 import (
+	"context"
+
 	"github.com/nfphys/simpledb-go/buffer"
 	"github.com/nfphys/simpledb-go/file"
 )
@@ -25,7 +27,11 @@ func (bl *BufferList) GetBuffer(blk *file.BlockId) *buffer.Buffer {
 }
 
 func (bl *BufferList) Pin(blk *file.BlockId) error {
-	buff, err := bl.bm.Pin(blk)
+	return bl.PinContext(context.Background(), blk)
+}
+
+func (bl *BufferList) PinContext(ctx context.Context, blk *file.BlockId) error {
+	buff, err := bl.bm.PinContext(ctx, blk)
 	if err != nil {
 		return err
 	}
@@ -56,9 +62,3 @@ func (bl *BufferList) UnpinAll() {
 	bl.buffers = make(map[file.BlockId]*buffer.Buffer)
 	bl.pins = make(map[file.BlockId]int)
 }
-
-func (bl *BufferList) FlushAll() {
-	for _, buff := range bl.buffers {
-		buff.Flush()
-	}
-}
@@ -22,17 +22,20 @@ func (cr *CheckpointRecord) TxNumber() int {
 	return -1
 }
 
-func (cr *CheckpointRecord) Undo(tx *Transaction) {
+func (cr *CheckpointRecord) Undo(tx *Transaction) error {
 	// No undo operation for CHECKPOINT record
+	return nil
 }
 
 func (cr *CheckpointRecord) ToString() string {
 	return fmt.Sprintf("<CHECKPOINT %d>", cr.TxNumber())
 }
 
-func WriteCheckpointRecordToLog(lm *log.LogMgr) int {
+func WriteCheckpointRecordToLog(lm *log.LogMgr) (int, error) {
 	rec := make([]byte, 4)
 	p := file.NewPageFromBytes(rec)
-	p.SetInt(0, CHECKPOINT)
+	if err := p.SetInt(0, CHECKPOINT); err != nil {
+		return 0, err
+	}
 	return lm.Append(rec)
 }
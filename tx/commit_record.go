@@ -11,10 +11,14 @@ type CommitRecord struct {
 	txnum int
 }
 
-func NewCommitRecord(p *file.Page) *CommitRecord {
-	return &CommitRecord{
-		txnum: p.GetInt(4),
+func NewCommitRecord(p *file.Page) (*CommitRecord, error) {
+	txnum, err := p.GetInt(4)
+	if err != nil {
+		return nil, err
 	}
+	return &CommitRecord{
+		txnum: txnum,
+	}, nil
 }
 
 func (cr *CommitRecord) Op() int {
@@ -25,18 +29,23 @@ func (cr *CommitRecord) TxNumber() int {
 	return cr.txnum
 }
 
-func (cr *CommitRecord) Undo(tx *Transaction) {
+func (cr *CommitRecord) Undo(tx *Transaction) error {
 	// No undo operation for COMMIT record
+	return nil
 }
 
 func (cr *CommitRecord) ToString() string {
 	return fmt.Sprintf("<COMMIT %d>", cr.txnum)
 }
 
-func WriteCommitRecordToLog(lm *log.LogMgr, txnum int) int {
+func WriteCommitRecordToLog(lm *log.LogMgr, txnum int) (int, error) {
 	rec := make([]byte, 8)
 	p := file.NewPageFromBytes(rec)
-	p.SetInt(0, COMMIT)
-	p.SetInt(4, txnum)
+	if err := p.SetInt(0, COMMIT); err != nil {
+		return 0, err
+	}
+	if err := p.SetInt(4, txnum); err != nil {
+		return 0, err
+	}
 	return lm.Append(rec)
 }
@@ -0,0 +1,90 @@
+package concurrency
+
+import (
+	"github.com/nfphys/simpledb-go/file"
+)
+
+const (
+	SLOCK = "S"
+	XLOCK = "X"
+)
+
+// ConcurrencyMgr はトランザクション 1 つ分のロックを覚えておき、
+// strict 2PL に従ってコミットかロールバックのときにまとめて外す。
+// 読むときのロックの長さは分離レベルで変わる。
+type ConcurrencyMgr struct {
+	lt *LockTable
+	txnum int
+	level IsolationLevel
+	locks map[file.BlockId]string
+}
+
+func NewConcurrencyMgr(lt *LockTable, txnum int, level IsolationLevel) *ConcurrencyMgr {
+	return &ConcurrencyMgr{
+		lt: lt,
+		txnum: txnum,
+		level: level,
+		locks: make(map[file.BlockId]string),
+	}
+}
+
+// ReadLock は分離レベルに従って blk を読むためのロックを取る。
+// 読み終わったら、返した関数を呼ぶこと。最後まで持たないロックはそこで外れる。
+func (cm *ConcurrencyMgr) ReadLock(blk *file.BlockId) (func(), error) {
+	switch {
+	case cm.level == ReadUncommitted:
+		return func() {}, nil
+	case cm.level == ReadCommitted, cm.level == RepeatableRead && blk.Number() == END_OF_FILE:
+		return cm.shortSLock(blk)
+	default:
+		return func() {}, cm.SLock(blk)
+	}
+}
+
+// shortSLock は読んでいる間だけ slock を持ち、返した関数で外す。
+// すでに何かのロックを持っていれば、それを最後まで持つので何もしない。
+func (cm *ConcurrencyMgr) shortSLock(blk *file.BlockId) (func(), error) {
+	if _, ok := cm.locks[*blk]; ok {
+		return func() {}, nil
+	}
+	if err := cm.lt.SLock(blk, cm.txnum); err != nil {
+		return func() {}, err
+	}
+	return func() { cm.lt.Unlock(blk, cm.txnum) }, nil
+}
+
+// SLock は blk の slock を取り、最後まで持つ。すでに何かのロックを持っていれば何もしない。
+func (cm *ConcurrencyMgr) SLock(blk *file.BlockId) error {
+	if _, ok := cm.locks[*blk]; ok {
+		return nil
+	}
+	if err := cm.lt.SLock(blk, cm.txnum); err != nil {
+		return err
+	}
+	cm.locks[*blk] = SLOCK
+	return nil
+}
+
+// XLock は blk の xlock を取る。slock を持っていなければ先に取ってから格上げする。
+func (cm *ConcurrencyMgr) XLock(blk *file.BlockId) error {
+	if cm.locks[*blk] == XLOCK {
+		return nil
+	}
+	if err := cm.SLock(blk); err != nil {
+		return err
+	}
+	if err := cm.lt.XLock(blk, cm.txnum); err != nil {
+		return err
+	}
+	cm.locks[*blk] = XLOCK
+	return nil
+}
+
+// Release は持っているロックをすべて外す。
+func (cm *ConcurrencyMgr) Release() {
+	for blk := range cm.locks {
+		cm.lt.Unlock(&blk, cm.txnum)
+	}
+	cm.lt.done(cm.txnum)
+	cm.locks = make(map[file.BlockId]string)
+}
@@ -0,0 +1,156 @@
+package concurrency_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/tx/concurrency"
+)
+
+// lockAsync は別のゴルーチンで lock を呼び、結果を返すチャネルを返す。
+func lockAsync(lock func() error) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- lock()
+	}()
+	time.Sleep(10 * time.Millisecond) // 待ち始めるまで待つ
+	return done
+}
+
+func receive(t *testing.T, done <-chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		t.Fatalf("Expected lock request to finish")
+		return nil
+	}
+}
+
+func TestDeadlockAbortsRequester(t *testing.T) {
+	// Given
+	lt := concurrency.NewLockTable()
+	blkA := file.NewBlockId("testfile", 0)
+	blkB := file.NewBlockId("testfile", 1)
+	cm1 := concurrency.NewConcurrencyMgr(lt, 1, concurrency.Serializable)
+	cm2 := concurrency.NewConcurrencyMgr(lt, 2, concurrency.Serializable)
+	cm1.XLock(blkA)
+	cm2.XLock(blkB)
+	done := lockAsync(func() error { return cm1.XLock(blkB) })
+
+	// When
+	err := cm2.XLock(blkA)
+
+	// Then
+	if !errors.Is(err, concurrency.ErrDeadlock) {
+		t.Fatalf("Expected ErrDeadlock, got %v", err)
+	}
+	if !errors.Is(err, concurrency.ErrLockAbort) {
+		t.Errorf("Expected ErrDeadlock to be ErrLockAbort")
+	}
+	cm2.Release()
+	if err := receive(t, done); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestDeadlockAbortsYoungestWaiter(t *testing.T) {
+	// Given
+	lt := concurrency.NewLockTable()
+	blkA := file.NewBlockId("testfile", 0)
+	blkB := file.NewBlockId("testfile", 1)
+	cm1 := concurrency.NewConcurrencyMgr(lt, 1, concurrency.Serializable)
+	cm2 := concurrency.NewConcurrencyMgr(lt, 2, concurrency.Serializable)
+	cm1.XLock(blkA)
+	cm2.XLock(blkB)
+	victim := lockAsync(func() error { return cm2.XLock(blkA) })
+
+	// When
+	done := lockAsync(func() error { return cm1.XLock(blkB) })
+
+	// Then
+	if err := receive(t, victim); !errors.Is(err, concurrency.ErrDeadlock) {
+		t.Fatalf("Expected ErrDeadlock, got %v", err)
+	}
+	cm2.Release()
+	if err := receive(t, done); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestDeadlockOnLockUpgrade(t *testing.T) {
+	// Given
+	lt := concurrency.NewLockTable()
+	blk := file.NewBlockId("testfile", 0)
+	cm1 := concurrency.NewConcurrencyMgr(lt, 1, concurrency.Serializable)
+	cm2 := concurrency.NewConcurrencyMgr(lt, 2, concurrency.Serializable)
+	cm1.SLock(blk)
+	cm2.SLock(blk)
+	done := lockAsync(func() error { return cm1.XLock(blk) })
+
+	// When
+	err := cm2.XLock(blk)
+
+	// Then
+	if !errors.Is(err, concurrency.ErrDeadlock) {
+		t.Fatalf("Expected ErrDeadlock, got %v", err)
+	}
+	cm2.Release()
+	if err := receive(t, done); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestWaitDie(t *testing.T) {
+	// Given
+	lt := concurrency.NewLockTable(
+		concurrency.WithDeadlockPolicy(concurrency.WaitDie),
+		concurrency.WithMaxWait(20*time.Millisecond),
+	)
+	blkA := file.NewBlockId("testfile", 0)
+	blkB := file.NewBlockId("testfile", 1)
+	cm1 := concurrency.NewConcurrencyMgr(lt, 1, concurrency.Serializable)
+	cm2 := concurrency.NewConcurrencyMgr(lt, 2, concurrency.Serializable)
+	cm1.XLock(blkA)
+	cm2.XLock(blkB)
+
+	// When
+	younger := cm2.SLock(blkA)
+	older := cm1.SLock(blkB)
+
+	// Then
+	if !errors.Is(younger, concurrency.ErrDeadlock) {
+		t.Errorf("Expected younger transaction to die, got %v", younger)
+	}
+	if errors.Is(older, concurrency.ErrDeadlock) || !errors.Is(older, concurrency.ErrLockAbort) {
+		t.Errorf("Expected older transaction to wait until timeout, got %v", older)
+	}
+}
+
+func TestWoundWait(t *testing.T) {
+	// Given
+	lt := concurrency.NewLockTable(concurrency.WithDeadlockPolicy(concurrency.WoundWait))
+	blkA := file.NewBlockId("testfile", 0)
+	blkB := file.NewBlockId("testfile", 1)
+	cm1 := concurrency.NewConcurrencyMgr(lt, 1, concurrency.Serializable)
+	cm2 := concurrency.NewConcurrencyMgr(lt, 2, concurrency.Serializable)
+	cm2.XLock(blkA)
+
+	// When
+	done := lockAsync(func() error { return cm1.SLock(blkA) })
+
+	// Then
+	if err := cm2.SLock(blkB); !errors.Is(err, concurrency.ErrDeadlock) {
+		t.Fatalf("Expected wounded transaction to abort, got %v", err)
+	}
+	cm2.Release()
+	if err := receive(t, done); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	cm3 := concurrency.NewConcurrencyMgr(lt, 3, concurrency.Serializable)
+	if err := cm3.SLock(blkB); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
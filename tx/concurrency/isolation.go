@@ -0,0 +1,46 @@
+package concurrency
+
+import (
+	"github.com/nfphys/simpledb-go/file"
+)
+
+const (
+	END_OF_FILE = -1 // ファイルの末尾を表すブロック番号。ブロック数を数えるときと足すときにロックする
+)
+
+// IsolationLevel はトランザクションが読むときに取るロックの長さを決める。
+// 書くときの xlock はどのレベルでもコミットかロールバックまで持つ。
+type IsolationLevel int
+
+const (
+	// ReadUncommitted は読むときにロックを取らないので、コミットしていない変更が見える。
+	ReadUncommitted IsolationLevel = iota
+	// ReadCommitted は読むたびに slock を取ってすぐ外す。コミットした変更だけが見えるが、
+	// 同じブロックを読み直すと値が変わっていることがある。
+	ReadCommitted
+	// RepeatableRead は読んだブロックの slock を最後まで持つ。ただしファイルの末尾の
+	// slock はすぐ外すので、数え直すとブロックが増えていることがある (ファントム)。
+	RepeatableRead
+	// Serializable はファイルの末尾も含めてすべての slock を最後まで持つ。既定のレベル。
+	Serializable
+)
+
+func (l IsolationLevel) String() string {
+	switch l {
+	case ReadUncommitted:
+		return "READ UNCOMMITTED"
+	case ReadCommitted:
+		return "READ COMMITTED"
+	case RepeatableRead:
+		return "REPEATABLE READ"
+	case Serializable:
+		return "SERIALIZABLE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// EndOfFile は filename の末尾を表すブロックを返す。
+func EndOfFile(filename string) *file.BlockId {
+	return file.NewBlockId(filename, END_OF_FILE)
+}
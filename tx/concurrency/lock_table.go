@@ -0,0 +1,280 @@
+package concurrency
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nfphys/simpledb-go/file"
+)
+
+const (
+	MAX_TIME = 10000 // 10 seconds。WithMaxWait で変えられる
+)
+
+var (
+	ErrLockAbort = errors.New("lock abort")
+	// ErrDeadlock はデッドロックを解くためにトランザクションが犠牲に選ばれたことを表す。
+	// errors.Is(err, ErrLockAbort) も true になるので、ロールバックしてやり直せばよい。
+	ErrDeadlock = fmt.Errorf("deadlock: %w", ErrLockAbort)
+)
+
+// DeadlockPolicy はロックを待つトランザクションどうしのデッドロックをどう扱うかを表す。
+type DeadlockPolicy int
+
+const (
+	// DetectDeadlock は待ちグラフ (wait-for graph) の閉路を見つけ、その中で最も新しい
+	// トランザクション (txnum が最大のもの) を ErrDeadlock で中断する。既定の方式。
+	DetectDeadlock DeadlockPolicy = iota
+	// WaitDie では古いトランザクションは新しいトランザクションを待つが、
+	// 新しいトランザクションは古いトランザクションを待たずに ErrDeadlock で中断する。
+	WaitDie
+	// WoundWait では古いトランザクションが新しいトランザクションのロックを待つとき、
+	// 新しいほうを中断させる。新しいトランザクションは古いトランザクションを待つ。
+	// 中断させられたトランザクションは、次にロックを取ろうとしたときに ErrDeadlock を受け取る。
+	WoundWait
+)
+
+// LockTable はブロックごとの共有ロック (slock) と排他ロック (xlock) を管理する。
+// 同じデータベースを使うトランザクションはすべて同じ LockTable を共有する。
+type LockTable struct {
+	locks map[file.BlockId]*lockEntry
+	waiting map[int]request // ロックを待っているトランザクション
+	aborted map[int]bool // デッドロックの犠牲に選ばれたトランザクション
+	released chan struct{} // ロックが外れるたびに close して作り直す
+	maxWait time.Duration
+	policy DeadlockPolicy
+	mu sync.Mutex
+}
+
+type lockEntry struct {
+	slocks map[int]bool
+	xlock int // xlock を持つトランザクション。-1 なら誰も持っていない
+}
+
+// request はトランザクションが待っているロック。
+type request struct {
+	blk file.BlockId
+	x bool
+}
+
+func NewLockTable(opts ...Option) *LockTable {
+	lt := &LockTable{
+		locks: make(map[file.BlockId]*lockEntry),
+		waiting: make(map[int]request),
+		aborted: make(map[int]bool),
+		released: make(chan struct{}),
+		maxWait: MAX_TIME * time.Millisecond,
+		policy: DetectDeadlock,
+		mu: sync.Mutex{},
+	}
+	for _, opt := range opts {
+		opt(lt)
+	}
+	return lt
+}
+
+// SLock は txnum のために blk の slock を取る。他のトランザクションの xlock が外れるのを
+// 待ちきれなければ ErrLockAbort を、デッドロックの犠牲になれば ErrDeadlock を返す。
+func (lt *LockTable) SLock(blk *file.BlockId, txnum int) error {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if err := lt.wait(txnum, request{blk: *blk}); err != nil {
+		return fmt.Errorf("concurrency: slock %v: %w", blk, err)
+	}
+	lt.entry(blk).slocks[txnum] = true
+	return nil
+}
+
+// XLock は txnum のために blk の xlock を取る。txnum がすでに slock を持っていれば格上げする。
+// 他のトランザクションのロックが外れるのを待ちきれなければ ErrLockAbort を、
+// デッドロックの犠牲になれば ErrDeadlock を返す。
+func (lt *LockTable) XLock(blk *file.BlockId, txnum int) error {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if err := lt.wait(txnum, request{blk: *blk, x: true}); err != nil {
+		return fmt.Errorf("concurrency: xlock %v: %w", blk, err)
+	}
+	e := lt.entry(blk)
+	e.slocks[txnum] = true
+	e.xlock = txnum
+	return nil
+}
+
+// Unlock は txnum が持つ blk のロックを外し、待っているトランザクションを起こす。
+func (lt *LockTable) Unlock(blk *file.BlockId, txnum int) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if e, ok := lt.locks[*blk]; ok {
+		delete(e.slocks, txnum)
+		if e.xlock == txnum {
+			e.xlock = -1
+		}
+		if len(e.slocks) == 0 && e.xlock < 0 {
+			delete(lt.locks, *blk)
+		}
+	}
+	lt.wakeAll()
+}
+
+// done は txnum が終わったときに呼ばれ、犠牲に選ばれた印を消す。
+func (lt *LockTable) done(txnum int) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	delete(lt.aborted, txnum)
+}
+
+func (lt *LockTable) entry(blk *file.BlockId) *lockEntry {
+	e, ok := lt.locks[*blk]
+	if !ok {
+		e = &lockEntry{slocks: make(map[int]bool), xlock: -1}
+		lt.locks[*blk] = e
+	}
+	return e
+}
+
+// blockers は txnum が req のロックを取るのを妨げているトランザクションを返す。
+func (lt *LockTable) blockers(txnum int, req request) []int {
+	e, ok := lt.locks[req.blk]
+	if !ok {
+		return nil
+	}
+	blockers := []int{}
+	if e.xlock >= 0 && e.xlock != txnum {
+		blockers = append(blockers, e.xlock)
+	}
+	if req.x {
+		for t := range e.slocks {
+			if t != txnum && t != e.xlock {
+				blockers = append(blockers, t)
+			}
+		}
+	}
+	return blockers
+}
+
+// wait は txnum が req のロックを取れるようになるまでロックを外して待つ。lt.mu を取った状態で呼ぶ。
+func (lt *LockTable) wait(txnum int, req request) error {
+	if lt.aborted[txnum] {
+		return ErrDeadlock
+	}
+	if len(lt.blockers(txnum, req)) == 0 {
+		return nil
+	}
+
+	lt.waiting[txnum] = req
+	defer delete(lt.waiting, txnum)
+
+	timer := time.NewTimer(lt.maxWait)
+	defer timer.Stop()
+
+	for {
+		if lt.aborted[txnum] {
+			return ErrDeadlock
+		}
+		blockers := lt.blockers(txnum, req)
+		if len(blockers) == 0 {
+			return nil
+		}
+		if err := lt.resolve(txnum, blockers); err != nil {
+			return err
+		}
+
+		released := lt.released
+		lt.mu.Unlock()
+		select {
+		case <-released:
+			lt.mu.Lock()
+		case <-timer.C:
+			lt.mu.Lock()
+			if lt.aborted[txnum] {
+				return ErrDeadlock
+			}
+			if len(lt.blockers(txnum, req)) > 0 {
+				return ErrLockAbort
+			}
+			return nil
+		}
+	}
+}
+
+// resolve は txnum が blockers を待ってよいかを policy に従って決める。
+// 待てなければ ErrDeadlock を返し、他のトランザクションを中断させるときはその印をつけて起こす。
+func (lt *LockTable) resolve(txnum int, blockers []int) error {
+	switch lt.policy {
+	case WaitDie:
+		for _, t := range blockers {
+			if txnum > t {
+				return ErrDeadlock
+			}
+		}
+	case WoundWait:
+		for _, t := range blockers {
+			if txnum < t && !lt.aborted[t] {
+				lt.abort(t)
+			}
+		}
+	default:
+		cycle := lt.findCycle(txnum)
+		if len(cycle) == 0 {
+			return nil
+		}
+		victim := cycle[0]
+		for _, t := range cycle {
+			victim = max(victim, t)
+		}
+		if victim == txnum {
+			return ErrDeadlock
+		}
+		lt.abort(victim)
+	}
+	return nil
+}
+
+// findCycle は待ちグラフで txnum から txnum に戻る閉路をたどり、その上のトランザクションを返す。
+// 閉路がなければ nil を返す。犠牲に選ばれて抜けていくトランザクションは数えない。
+func (lt *LockTable) findCycle(txnum int) []int {
+	visited := map[int]bool{}
+	var path []int
+	var visit func(t int) bool
+	visit = func(t int) bool {
+		path = append(path, t)
+		req, ok := lt.waiting[t]
+		if ok && !lt.aborted[t] {
+			for _, next := range lt.blockers(t, req) {
+				if next == txnum {
+					return true
+				}
+				if !visited[next] {
+					visited[next] = true
+					if visit(next) {
+						return true
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	visited[txnum] = true
+	if visit(txnum) {
+		return path
+	}
+	return nil
+}
+
+// abort は txnum をデッドロックの犠牲にし、待っていれば起こす。
+func (lt *LockTable) abort(txnum int) {
+	lt.aborted[txnum] = true
+	lt.wakeAll()
+}
+
+func (lt *LockTable) wakeAll() {
+	close(lt.released)
+	lt.released = make(chan struct{})
+}
@@ -0,0 +1,135 @@
+package concurrency_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/tx/concurrency"
+)
+
+func TestSLockIsShared(t *testing.T) {
+	// Given
+	lt := concurrency.NewLockTable(concurrency.WithMaxWait(10 * time.Millisecond))
+	blk := file.NewBlockId("testfile", 0)
+
+	// When
+	err1 := lt.SLock(blk, 1)
+	err2 := lt.SLock(blk, 2)
+
+	// Then
+	if err1 != nil || err2 != nil {
+		t.Fatalf("Expected no error, got %v, %v", err1, err2)
+	}
+}
+
+func TestXLockTimesOut(t *testing.T) {
+	// Given
+	lt := concurrency.NewLockTable(concurrency.WithMaxWait(10 * time.Millisecond))
+	blk := file.NewBlockId("testfile", 0)
+	if err := lt.SLock(blk, 1); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := lt.XLock(blk, 1); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	err := lt.SLock(blk, 2)
+
+	// Then
+	if !errors.Is(err, concurrency.ErrLockAbort) {
+		t.Errorf("Expected ErrLockAbort, got %v", err)
+	}
+}
+
+func TestXLockWaitsForOtherSLocks(t *testing.T) {
+	// Given
+	lt := concurrency.NewLockTable(concurrency.WithMaxWait(10 * time.Millisecond))
+	blk := file.NewBlockId("testfile", 0)
+	lt.SLock(blk, 1)
+	lt.SLock(blk, 2)
+
+	// When
+	err := lt.XLock(blk, 1)
+
+	// Then
+	if !errors.Is(err, concurrency.ErrLockAbort) {
+		t.Errorf("Expected ErrLockAbort, got %v", err)
+	}
+}
+
+func TestUnlockWakesWaiter(t *testing.T) {
+	// Given
+	lt := concurrency.NewLockTable()
+	blk := file.NewBlockId("testfile", 0)
+	lt.SLock(blk, 1)
+	lt.XLock(blk, 1)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- lt.SLock(blk, 2)
+	}()
+
+	// When
+	time.Sleep(10 * time.Millisecond)
+	lt.Unlock(blk, 1)
+
+	// Then
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected waiter to get slock after unlock")
+	}
+}
+
+func TestConcurrencyMgrRelease(t *testing.T) {
+	// Given
+	lt := concurrency.NewLockTable(concurrency.WithMaxWait(10 * time.Millisecond))
+	blk := file.NewBlockId("testfile", 0)
+	cm1 := concurrency.NewConcurrencyMgr(lt, 1, concurrency.Serializable)
+	cm2 := concurrency.NewConcurrencyMgr(lt, 2, concurrency.Serializable)
+	if err := cm1.XLock(blk); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := cm2.SLock(blk); !errors.Is(err, concurrency.ErrLockAbort) {
+		t.Fatalf("Expected ErrLockAbort, got %v", err)
+	}
+
+	// When
+	cm1.Release()
+
+	// Then
+	if err := cm2.XLock(blk); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestReadCommittedHoldsSLockWhileReading(t *testing.T) {
+	// Given
+	lt := concurrency.NewLockTable(concurrency.WithMaxWait(10 * time.Millisecond))
+	blk := file.NewBlockId("testfile", 0)
+	reader := concurrency.NewConcurrencyMgr(lt, 1, concurrency.ReadCommitted)
+	writer := concurrency.NewConcurrencyMgr(lt, 2, concurrency.Serializable)
+
+	// When
+	release, err := reader.ReadLock(blk)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	duringErr := writer.XLock(blk)
+	release()
+	afterErr := writer.XLock(blk)
+
+	// Then
+	if !errors.Is(duringErr, concurrency.ErrLockAbort) {
+		t.Errorf("Expected writer to wait while the read is in progress, got %v", duringErr)
+	}
+	if afterErr != nil {
+		t.Errorf("Expected slock to be released after the read, got %v", afterErr)
+	}
+}
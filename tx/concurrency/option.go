@@ -0,0 +1,21 @@
+package concurrency
+
+import (
+	"time"
+)
+
+type Option func(*LockTable)
+
+// WithMaxWait はロックが外れるのを待つ時間を d にする。過ぎたら ErrLockAbort になる。
+func WithMaxWait(d time.Duration) Option {
+	return func(lt *LockTable) {
+		lt.maxWait = d
+	}
+}
+
+// WithDeadlockPolicy はデッドロックの扱い方を p にする。既定は DetectDeadlock。
+func WithDeadlockPolicy(p DeadlockPolicy) Option {
+	return func(lt *LockTable) {
+		lt.policy = p
+	}
+}
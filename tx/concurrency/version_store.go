@@ -0,0 +1,189 @@
+package concurrency
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nfphys/simpledb-go/file"
+)
+
+var (
+	ErrWriteConflict = errors.New("write conflict")
+)
+
+// VersionStore はブロックの古い版を保持し、スナップショットを使うトランザクションに
+// 開始時点の内容を見せる。書き込むトランザクションは最初の変更の前に変更前のページを残し、
+// コミットするとその版に時刻がつく。同じデータベースを使うトランザクションはすべて
+// 同じ VersionStore を共有する。
+type VersionStore struct {
+	clock int64 // 最後にコミットした書き込みの時刻
+	versions map[file.BlockId][]*version // 古いものから順
+	written map[int][]file.BlockId // コミットしていないトランザクションが版を残したブロック
+	snapshots map[int]int64 // 動いているスナップショットの開始時刻
+	mu sync.Mutex
+}
+
+// version は上書きされる前のページ。end は上書きしたトランザクションのコミット時刻で、
+// まだコミットしていなければ 0。
+type version struct {
+	page *file.Page
+	writer int
+	end int64
+}
+
+func NewVersionStore() *VersionStore {
+	return &VersionStore{
+		versions: make(map[file.BlockId][]*version),
+		written: make(map[int][]file.BlockId),
+		snapshots: make(map[int]int64),
+		mu: sync.Mutex{},
+	}
+}
+
+// Begin は txnum のスナップショットを取り、その時刻を返す。
+func (vs *VersionStore) Begin(txnum int) int64 {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	vs.snapshots[txnum] = vs.clock
+	return vs.clock
+}
+
+// BeforeWrite は txnum が blk を初めて変更する前に、変更前のページ p を残す。
+// txnum は blk の xlock を持っていること。
+func (vs *VersionStore) BeforeWrite(blk *file.BlockId, txnum int, p *file.Page) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	chain := vs.versions[*blk]
+	if n := len(chain); n > 0 && chain[n-1].writer == txnum && chain[n-1].end == 0 {
+		return
+	}
+	vs.versions[*blk] = append(chain, &version{page: p.Clone(), writer: txnum})
+	vs.written[txnum] = append(vs.written[txnum], *blk)
+}
+
+// View は時刻 ts のスナップショットから見た blk の内容を fn に渡す。current は
+// バッファに載っている今のページで、txnum 自身が変更したブロックはこちらを読む。
+func (vs *VersionStore) View(blk *file.BlockId, txnum int, ts int64, current *file.Page, fn func(p *file.Page)) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	for _, v := range vs.versions[*blk] {
+		if v.writer == txnum && v.end == 0 {
+			break
+		}
+		// ts より後に上書きされた版のうち最も古いものが、ts の時点の内容
+		if v.end == 0 || v.end > ts {
+			fn(v.page)
+			return
+		}
+	}
+	fn(current)
+}
+
+// CheckWrite は時刻 ts のスナップショットを使うトランザクションが blk に書いてよいかを調べる。
+// ts より後に他のトランザクションが blk を変更してコミットしていれば ErrWriteConflict を返す
+// (first-committer-wins)。blk の xlock を取ってから呼ぶこと。
+func (vs *VersionStore) CheckWrite(blk *file.BlockId, ts int64) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	for _, v := range vs.versions[*blk] {
+		if v.end > ts {
+			return fmt.Errorf("concurrency: write %v: %w", blk, ErrWriteConflict)
+		}
+	}
+	return nil
+}
+
+// Commit は txnum が残した版にコミット時刻をつけ、スナップショットを終える。
+func (vs *VersionStore) Commit(txnum int) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	if blks := vs.written[txnum]; len(blks) > 0 {
+		vs.clock++
+		for _, blk := range blks {
+			for _, v := range vs.versions[blk] {
+				if v.writer == txnum && v.end == 0 {
+					v.end = vs.clock
+				}
+			}
+		}
+	}
+	vs.finish(txnum)
+}
+
+// Abort は txnum が残した版を捨て、スナップショットを終える。
+// 変更はすでに取り消してあること。
+func (vs *VersionStore) Abort(txnum int) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	for _, blk := range vs.written[txnum] {
+		chain := vs.versions[blk][:0]
+		for _, v := range vs.versions[blk] {
+			if v.writer != txnum || v.end != 0 {
+				chain = append(chain, v)
+			}
+		}
+		vs.setChain(blk, chain)
+	}
+	vs.finish(txnum)
+}
+
+// Collect はどのスナップショットからも見えなくなった版を捨て、その数を返す。
+// トランザクションが終わるたびに呼ばれる。
+func (vs *VersionStore) Collect() int {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	return vs.collect()
+}
+
+// Versions は保持している版の数を返す。
+func (vs *VersionStore) Versions() int {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	n := 0
+	for _, chain := range vs.versions {
+		n += len(chain)
+	}
+	return n
+}
+
+func (vs *VersionStore) finish(txnum int) {
+	delete(vs.written, txnum)
+	delete(vs.snapshots, txnum)
+	vs.collect()
+}
+
+func (vs *VersionStore) collect() int {
+	horizon := vs.clock
+	for _, ts := range vs.snapshots {
+		horizon = min(horizon, ts)
+	}
+
+	removed := 0
+	for blk, chain := range vs.versions {
+		// 版は上書きされた順に並ぶので、捨てられるのは先頭から
+		i := 0
+		for i < len(chain) && chain[i].end != 0 && chain[i].end <= horizon {
+			i++
+		}
+		removed += i
+		vs.setChain(blk, chain[i:])
+	}
+	return removed
+}
+
+func (vs *VersionStore) setChain(blk file.BlockId, chain []*version) {
+	if len(chain) == 0 {
+		delete(vs.versions, blk)
+		return
+	}
+	vs.versions[blk] = chain
+}
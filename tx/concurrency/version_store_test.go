@@ -0,0 +1,111 @@
+package concurrency_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/tx/concurrency"
+)
+
+func readInt(vs *concurrency.VersionStore, blk *file.BlockId, txnum int, ts int64, current *file.Page) int {
+	var val int
+	vs.View(blk, txnum, ts, current, func(p *file.Page) {
+		val, _ = p.GetInt(0)
+	})
+	return val
+}
+
+func TestViewSeesSnapshot(t *testing.T) {
+	// Given
+	vs := concurrency.NewVersionStore()
+	blk := file.NewBlockId("testfile", 0)
+	page := file.NewPage(64)
+	page.SetInt(0, 1)
+	ts := vs.Begin(1)
+
+	// When
+	vs.BeforeWrite(blk, 2, page)
+	page.SetInt(0, 2)
+	uncommitted := readInt(vs, blk, 1, ts, page)
+	own := readInt(vs, blk, 2, 0, page)
+	vs.Commit(2)
+	committed := readInt(vs, blk, 1, ts, page)
+	later := readInt(vs, blk, 3, vs.Begin(3), page)
+
+	// Then
+	if uncommitted != 1 || committed != 1 {
+		t.Errorf("Expected snapshot to see 1, got %d and %d", uncommitted, committed)
+	}
+	if later != 2 {
+		t.Errorf("Expected later snapshot to see 2, got %d", later)
+	}
+	if own != 2 {
+		t.Errorf("Expected writer to see its own write, got %d", own)
+	}
+}
+
+func TestCheckWrite(t *testing.T) {
+	// Given
+	vs := concurrency.NewVersionStore()
+	blk := file.NewBlockId("testfile", 0)
+	page := file.NewPage(64)
+	ts := vs.Begin(1)
+
+	// When
+	vs.BeforeWrite(blk, 2, page)
+	vs.Commit(2)
+
+	// Then
+	if err := vs.CheckWrite(blk, ts); !errors.Is(err, concurrency.ErrWriteConflict) {
+		t.Errorf("Expected ErrWriteConflict, got %v", err)
+	}
+	if err := vs.CheckWrite(blk, vs.Begin(3)); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestCollectKeepsVersionsForActiveSnapshots(t *testing.T) {
+	// Given
+	vs := concurrency.NewVersionStore()
+	blk := file.NewBlockId("testfile", 0)
+	page := file.NewPage(64)
+	page.SetInt(0, 1)
+	ts := vs.Begin(1)
+	for txnum := 2; txnum <= 4; txnum++ {
+		vs.BeforeWrite(blk, txnum, page)
+		page.SetInt(0, txnum)
+		vs.Commit(txnum)
+	}
+
+	// When
+	removed := vs.Collect()
+
+	// Then
+	if removed != 0 || vs.Versions() != 3 {
+		t.Fatalf("Expected 3 versions to be kept, removed %d, kept %d", removed, vs.Versions())
+	}
+	if val := readInt(vs, blk, 1, ts, page); val != 1 {
+		t.Errorf("Expected 1, got %d", val)
+	}
+	vs.Commit(1)
+	if vs.Versions() != 0 {
+		t.Errorf("Expected all versions to be collected, got %d", vs.Versions())
+	}
+}
+
+func TestAbortDropsVersions(t *testing.T) {
+	// Given
+	vs := concurrency.NewVersionStore()
+	blk := file.NewBlockId("testfile", 0)
+	page := file.NewPage(64)
+	vs.BeforeWrite(blk, 1, page)
+
+	// When
+	vs.Abort(1)
+
+	// Then
+	if vs.Versions() != 0 {
+		t.Errorf("Expected 0 versions, got %d", vs.Versions())
+	}
+}
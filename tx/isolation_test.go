@@ -0,0 +1,184 @@
+package tx_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nfphys/simpledb-go/buffer"
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/log"
+	"github.com/nfphys/simpledb-go/tx"
+	"github.com/nfphys/simpledb-go/tx/concurrency"
+)
+
+// 各レベルで起こりうる異常。起こらないはずの異常は、書く側か読む側がロックを待ちきれずに止まる。
+var anomalies = []struct {
+	level concurrency.IsolationLevel
+	dirtyRead bool
+	nonRepeatableRead bool
+	phantom bool
+}{
+	{concurrency.ReadUncommitted, true, true, true},
+	{concurrency.ReadCommitted, false, true, true},
+	{concurrency.RepeatableRead, false, false, true},
+	{concurrency.Serializable, false, false, false},
+}
+
+type isolationDB struct {
+	fm *file.FileMgr
+	lm *log.LogMgr
+	bm *buffer.BufferMgr
+	shared *tx.Shared
+}
+
+func setupIsolation(t *testing.T) *isolationDB {
+	fm := setup(t, 400)
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	db := &isolationDB{
+		fm: fm,
+		lm: lm,
+		bm: buffer.NewBufferMgr(fm, lm, 8),
+		shared: tx.NewShared(concurrency.WithMaxWait(10 * time.Millisecond)),
+	}
+
+	// testfile に 1 ブロックだけ書いておく
+	tx0 := db.begin(t, concurrency.Serializable)
+	blk, err := tx0.Append("testfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tx0.Pin(blk)
+	tx0.SetInt(blk, 0, 1)
+	if err := tx0.Commit(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return db
+}
+
+func (db *isolationDB) begin(t *testing.T, level concurrency.IsolationLevel) *tx.Transaction {
+	tx1, err := tx.NewTransaction(db.fm, db.lm, db.bm, db.shared, tx.WithIsolation(level))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return tx1
+}
+
+func TestDirtyRead(t *testing.T) {
+	for _, a := range anomalies {
+		t.Run(a.level.String(), func(t *testing.T) {
+			// Given
+			db := setupIsolation(t)
+			defer cleanup(db.fm)
+			blk := file.NewBlockId("testfile", 0)
+
+			writer := db.begin(t, concurrency.Serializable)
+			writer.Pin(blk)
+			if err := writer.SetInt(blk, 0, 2); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			reader := db.begin(t, a.level)
+			reader.Pin(blk)
+
+			// When
+			i, err := reader.GetInt(blk, 0)
+
+			// Then
+			if a.dirtyRead {
+				if err != nil || i != 2 {
+					t.Errorf("Expected dirty read of 2, got %d, %v", i, err)
+				}
+			} else if !errors.Is(err, concurrency.ErrLockAbort) {
+				t.Errorf("Expected reader to wait for the writer, got %d, %v", i, err)
+			}
+			writer.Rollback()
+			reader.Rollback()
+		})
+	}
+}
+
+func TestNonRepeatableRead(t *testing.T) {
+	for _, a := range anomalies {
+		t.Run(a.level.String(), func(t *testing.T) {
+			// Given
+			db := setupIsolation(t)
+			defer cleanup(db.fm)
+			blk := file.NewBlockId("testfile", 0)
+
+			reader := db.begin(t, a.level)
+			reader.Pin(blk)
+			first, err := reader.GetInt(blk, 0)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			// When
+			writer := db.begin(t, concurrency.Serializable)
+			writer.Pin(blk)
+			werr := writer.SetInt(blk, 0, 2)
+			if werr == nil {
+				writer.Commit()
+			} else {
+				writer.Rollback()
+			}
+			second, err := reader.GetInt(blk, 0)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			// Then
+			if a.nonRepeatableRead {
+				if werr != nil || first != 1 || second != 2 {
+					t.Errorf("Expected to read 1 then 2, got %d then %d (%v)", first, second, werr)
+				}
+			} else if !errors.Is(werr, concurrency.ErrLockAbort) || first != second {
+				t.Errorf("Expected writer to wait for the reader, got %d then %d (%v)", first, second, werr)
+			}
+			reader.Commit()
+		})
+	}
+}
+
+func TestPhantom(t *testing.T) {
+	for _, a := range anomalies {
+		t.Run(a.level.String(), func(t *testing.T) {
+			// Given
+			db := setupIsolation(t)
+			defer cleanup(db.fm)
+
+			reader := db.begin(t, a.level)
+			first, err := reader.Size("testfile")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			// When
+			writer := db.begin(t, concurrency.Serializable)
+			blk, werr := writer.Append("testfile")
+			if werr == nil {
+				writer.Pin(blk)
+				writer.SetInt(blk, 0, 2)
+				writer.Commit()
+			} else {
+				writer.Rollback()
+			}
+			second, err := reader.Size("testfile")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			// Then
+			if a.phantom {
+				if werr != nil || first != 1 || second != 2 {
+					t.Errorf("Expected 1 then 2 blocks, got %d then %d (%v)", first, second, werr)
+				}
+			} else if !errors.Is(werr, concurrency.ErrLockAbort) || first != second {
+				t.Errorf("Expected appender to wait for the reader, got %d then %d (%v)", first, second, werr)
+			}
+			reader.Commit()
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package tx
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/nfphys/simpledb-go/file"
 )
 
@@ -11,21 +14,27 @@ const (
 	ROLLBACK = 3
 	SETINT = 4
 	SETSTRING = 5
+	SAVEPOINT = 6
 )
 
+var ErrUnknownLogRecord = errors.New("unknown log record type")
+
 type LogRecord interface {
 	Op() int
 	TxNumber() int
-	Undo(tx *Transaction)
+	Undo(tx *Transaction) error
 	ToString() string
 }
 
-func CreateLogRecord(bytes []byte) LogRecord {
+func CreateLogRecord(bytes []byte) (LogRecord, error) {
 	p := file.NewPageFromBytes(bytes)
-	op := p.GetInt(0)
+	op, err := p.GetInt(0)
+	if err != nil {
+		return nil, fmt.Errorf("tx: log record: %w", err)
+	}
 	switch op {
 	case CHECKPOINT:
-		return NewCheckpointRecord()
+		return NewCheckpointRecord(), nil
 	case START:
 		return NewStartRecord(p)
 	case COMMIT:
@@ -36,7 +45,9 @@ func CreateLogRecord(bytes []byte) LogRecord {
 		return NewSetIntRecord(p)
 	case SETSTRING:
 		return NewSetStringRecord(p)
+	case SAVEPOINT:
+		return NewSavepointRecord(p)
 	default:
-		panic("Unknown log record type")
+		return nil, fmt.Errorf("tx: log record type %d: %w", op, ErrUnknownLogRecord)
 	}
 }
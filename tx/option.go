@@ -0,0 +1,28 @@
+package tx
+
+import (
+	"github.com/nfphys/simpledb-go/tx/concurrency"
+)
+
+type config struct {
+	snapshot bool
+	level concurrency.IsolationLevel
+}
+
+type Option func(*config)
+
+// WithSnapshot はトランザクションに開始時点のスナップショットを読ませる。
+// 読むときはロックを取らず、書くときはスナップショットの後に他のトランザクションが
+// コミットしたブロックに書こうとすると concurrency.ErrWriteConflict になる。
+func WithSnapshot() Option {
+	return func(c *config) {
+		c.snapshot = true
+	}
+}
+
+// WithIsolation はトランザクションの分離レベルを level にする。既定は concurrency.Serializable。
+func WithIsolation(level concurrency.IsolationLevel) Option {
+	return func(c *config) {
+		c.level = level
+	}
+}
@@ -1,6 +1,8 @@
 package recovery
 
 import (
+	"fmt"
+
 	"github.com/nfphys/simpledb-go/buffer"
 	"github.com/nfphys/simpledb-go/file"
 	"github.com/nfphys/simpledb-go/log"
@@ -13,35 +15,65 @@ type RecoveryMgr struct {
 	bm *buffer.BufferMgr
 }
 
-func NewRecoveryMgr(fm *file.FileMgr, lm *log.LogMgr, bm *buffer.BufferMgr) *RecoveryMgr {
-	tx := tx.NewTransaction(fm, lm, bm)
+// NewRecoveryMgr は undo に使うトランザクションを shared の上で始める。
+func NewRecoveryMgr(fm *file.FileMgr, lm *log.LogMgr, bm *buffer.BufferMgr, shared *tx.Shared) (*RecoveryMgr, error) {
+	tx, err := tx.NewTransaction(fm, lm, bm, shared)
+	if err != nil {
+		return nil, fmt.Errorf("recovery: %w", err)
+	}
 
 	return &RecoveryMgr{
 		tx: tx,
 		lm: lm,
 		bm: bm,
-	}
+	}, nil
 }
 
-func (rm *RecoveryMgr) Recover() {
-	rm.doRecover()
-	lsn := tx.WriteCheckpointRecordToLog(rm.lm)
-	rm.lm.Flush(lsn)
+func (rm *RecoveryMgr) Recover() error {
+	if err := rm.doRecover(); err != nil {
+		return fmt.Errorf("recovery: %w", err)
+	}
+	// undo したページを書いてからチェックポイントを打つ
+	if err := rm.bm.FlushAll(rm.tx.TxNum()); err != nil {
+		return fmt.Errorf("recovery: %w", err)
+	}
+	lsn, err := tx.WriteCheckpointRecordToLog(rm.lm)
+	if err != nil {
+		return fmt.Errorf("recovery: %w", err)
+	}
+	if err := rm.lm.Flush(lsn); err != nil {
+		return fmt.Errorf("recovery: %w", err)
+	}
+	// チェックポイントより前のレコードはもう Recover に必要ない
+	if _, err := rm.lm.Truncate(lsn); err != nil {
+		return fmt.Errorf("recovery: %w", err)
+	}
+	return nil
 }
 
-func (rm *RecoveryMgr) doRecover() {
+func (rm *RecoveryMgr) doRecover() error {
 	finishedTxs := make(map[int]bool)
-	for bytes := range rm.lm.Iterator() {
-		rec := tx.CreateLogRecord(bytes)
+	for bytes, err := range rm.lm.Iterator() {
+		if err != nil {
+			return err
+		}
+
+		rec, err := tx.CreateLogRecord(bytes)
+		if err != nil {
+			return err
+		}
 		switch rec.Op() {
 		case tx.CHECKPOINT:
-			return
+			return nil
 		case tx.COMMIT, tx.ROLLBACK:
 			finishedTxs[rec.TxNumber()] = true
 		default:
 			if !finishedTxs[rec.TxNumber()] {
-				rec.Undo(rm.tx)
+				if err := rec.Undo(rm.tx); err != nil {
+					return err
+				}
 			}
 		}
 	}
+	return nil
 }
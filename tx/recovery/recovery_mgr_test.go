@@ -0,0 +1,144 @@
+package recovery_test
+
+import (
+	"testing"
+
+	"github.com/nfphys/simpledb-go/buffer"
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/log"
+	"github.com/nfphys/simpledb-go/tx"
+	"github.com/nfphys/simpledb-go/tx/recovery"
+)
+
+func setup(t *testing.T) *file.FileMgr {
+	fm, err := file.NewFileMgrWithStore(file.NewMemStore(), 400)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return fm
+}
+
+func cleanup(fm *file.FileMgr) {
+	fm.Close()
+}
+
+// openLog は 1 セグメント 2 ブロックでログを開く。ブロックサイズ 400 では
+// 1 ブロックに収まるレコードは 10 個前後なので、数十件で複数のセグメントに分かれる。
+func openLog(t *testing.T, fm *file.FileMgr) *log.LogMgr {
+	lm, err := log.NewLogMgr(fm, "logfile", log.WithSegments(2, log.Retention{}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return lm
+}
+
+// crash はコミットした書き込みと、コミットせずにディスクまで届いた書き込みを残す。
+func crash(t *testing.T, fm *file.FileMgr, blk *file.BlockId) {
+	lm := openLog(t, fm)
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
+
+	for i := 1; i <= 20; i++ {
+		tx1, _ := tx.NewTransaction(fm, lm, bm, shared)
+		tx1.Pin(blk)
+		if err := tx1.SetInt(blk, 0, i); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if err := tx1.Commit(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	tx2, _ := tx.NewTransaction(fm, lm, bm, shared)
+	tx2.Pin(blk)
+	tx2.SetInt(blk, 0, 99)
+	tx2.SetString(blk, 4, "uncommitted")
+	if err := bm.FlushAll(tx2.TxNum()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestRecoverUndoesUncommittedWrites(t *testing.T) {
+	// Given
+	fm := setup(t)
+	defer cleanup(fm)
+	blk := file.NewBlockId("testfile", 0)
+	crash(t, fm, blk)
+
+	lm := openLog(t, fm)
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	rm, err := recovery.NewRecoveryMgr(fm, lm, bm, tx.NewShared())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	err = rm.Recover()
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	p := file.NewPage(fm.BlockSize())
+	if err := fm.Read(blk, p); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if v, _ := p.GetInt(0); v != 20  {
+		t.Errorf("Expected 20, got %d", v)
+	}
+	if s, _ := p.GetString(4); s != "" {
+		t.Errorf("Expected '', got '%s'", s)
+	}
+}
+
+func TestRecoverTruncatesLog(t *testing.T) {
+	// Given
+	fm := setup(t)
+	defer cleanup(fm)
+	blk := file.NewBlockId("testfile", 0)
+	crash(t, fm, blk)
+
+	lm := openLog(t, fm)
+	before, _ := lm.DiskUsage()
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	rm, _ := recovery.NewRecoveryMgr(fm, lm, bm, tx.NewShared())
+
+	// When
+	if err := rm.Recover(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Then
+	after, _ := lm.DiskUsage()
+	if before.Segments < 2 || after.Segments != 1 {
+		t.Errorf("Expected %d segments to shrink to 1, got %d", before.Segments, after.Segments)
+	}
+	var first tx.LogRecord
+	for bytes, err := range lm.Iterator() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		first, err = tx.CreateLogRecord(bytes)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		break
+	}
+	if first == nil || first.Op() != tx.CHECKPOINT {
+		t.Errorf("Expected newest record to be a checkpoint, got %v", first)
+	}
+
+	// 切り詰めたログを開き直して、もう一度 Recover できる
+	lm.Close()
+	lm = openLog(t, fm)
+	bm = buffer.NewBufferMgr(fm, lm, 3)
+	rm, _ = recovery.NewRecoveryMgr(fm, lm, bm, tx.NewShared())
+	if err := rm.Recover(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, err := range lm.ForwardIterator() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+}
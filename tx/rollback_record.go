@@ -11,10 +11,14 @@ type RollbackRecord struct {
 	txnum int
 }
 
-func NewRollbackRecord(p *file.Page) *RollbackRecord {
-	return &RollbackRecord{
-		txnum: p.GetInt(4),
+func NewRollbackRecord(p *file.Page) (*RollbackRecord, error) {
+	txnum, err := p.GetInt(4)
+	if err != nil {
+		return nil, err
 	}
+	return &RollbackRecord{
+		txnum: txnum,
+	}, nil
 }
 
 func (rr *RollbackRecord) Op() int {
@@ -25,18 +29,23 @@ func (rr *RollbackRecord) TxNumber() int {
 	return rr.txnum
 }
 
-func (rr *RollbackRecord) Undo(tx *Transaction) {
+func (rr *RollbackRecord) Undo(tx *Transaction) error {
 	// No undo operation for ROLLBACK record
+	return nil
 }
 
 func (rr *RollbackRecord) ToString() string {
 	return fmt.Sprintf("<ROLLBACK %d>", rr.txnum)
 }
 
-func WriteRollbackRecordToLog(lm *log.LogMgr, txnum int) int {
+func WriteRollbackRecordToLog(lm *log.LogMgr, txnum int) (int, error) {
 	rec := make([]byte, 8)
 	p := file.NewPageFromBytes(rec)
-	p.SetInt(0, ROLLBACK)
-	p.SetInt(4, txnum)
+	if err := p.SetInt(0, ROLLBACK); err != nil {
+		return 0, err
+	}
+	if err := p.SetInt(4, txnum); err != nil {
+		return 0, err
+	}
 	return lm.Append(rec)
 }
@@ -0,0 +1,69 @@
+package tx
+
+import (
+	"fmt"
+
+	"github.com/nfphys/simpledb-go/file"
+	"github.com/nfphys/simpledb-go/log"
+)
+
+// SavepointRecord はトランザクション内のセーブポイントの位置を示す。
+// RollbackTo はこのレコードまで戻ったところで undo をやめる。
+type SavepointRecord struct {
+	txnum int
+	name string
+}
+
+func NewSavepointRecord(p *file.Page) (*SavepointRecord, error) {
+	txnum, err := p.GetInt(4)
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.GetString(8)
+	if err != nil {
+		return nil, err
+	}
+	return &SavepointRecord{
+		txnum: txnum,
+		name: name,
+	}, nil
+}
+
+func (sr *SavepointRecord) Op() int {
+	return SAVEPOINT
+}
+
+func (sr *SavepointRecord) TxNumber() int {
+	return sr.txnum
+}
+
+func (sr *SavepointRecord) Name() string {
+	return sr.name
+}
+
+func (sr *SavepointRecord) Undo(tx *Transaction) error {
+	// No undo operation for SAVEPOINT record
+	return nil
+}
+
+func (sr *SavepointRecord) ToString() string {
+	return fmt.Sprintf("<SAVEPOINT %d %s>", sr.txnum, sr.name)
+}
+
+func WriteSavepointRecordToLog(lm *log.LogMgr, txnum int, name string) (int, error) {
+	tpos := 4
+	npos := tpos + 4
+
+	rec := make([]byte, npos + 4 + len(name))
+	p := file.NewPageFromBytes(rec)
+	if err := p.SetInt(0, SAVEPOINT); err != nil {
+		return 0, err
+	}
+	if err := p.SetInt(tpos, txnum); err != nil {
+		return 0, err
+	}
+	if err := p.SetString(npos, name); err != nil {
+		return 0, err
+	}
+	return lm.Append(rec)
+}
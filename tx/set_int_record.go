@@ -14,29 +14,44 @@ type SetIntRecord struct {
 	blk *file.BlockId
 }
 
-func NewSetIntRecord(p *file.Page) *SetIntRecord {
+func NewSetIntRecord(p *file.Page) (*SetIntRecord, error) {
 	tpos := 4
-	txnum := p.GetInt(4)
+	txnum, err := p.GetInt(4)
+	if err != nil {
+		return nil, err
+	}
 
 	fpos := tpos + 4
-	filename := p.GetString(8)
+	filename, err := p.GetString(8)
+	if err != nil {
+		return nil, err
+	}
 
 	bpos := fpos + 4 + len(filename)
-	blknum := p.GetInt(bpos)
+	blknum, err := p.GetInt(bpos)
+	if err != nil {
+		return nil, err
+	}
 	blk := file.NewBlockId(filename, blknum)
 
 	opos := bpos + 4
-	offset := p.GetInt(opos)
+	offset, err := p.GetInt(opos)
+	if err != nil {
+		return nil, err
+	}
 
 	vpos := opos + 4
-	val := p.GetInt(vpos)
+	val, err := p.GetInt(vpos)
+	if err != nil {
+		return nil, err
+	}
 
 	return &SetIntRecord{
 		txnum: txnum,
 		offset: offset,
 		val: val,
 		blk: blk,
-	}
+	}, nil
 }
 
 func (sir *SetIntRecord) Op() int {
@@ -47,17 +62,20 @@ func (sir *SetIntRecord) TxNumber() int {
 	return sir.txnum
 }
 
-func (sir *SetIntRecord) Undo(tx *Transaction) {
-	tx.Pin(sir.blk)
-	tx.setIntWithoutLog(sir.blk, sir.offset, sir.val)
-	tx.Unpin(sir.blk)
+func (sir *SetIntRecord) Undo(tx *Transaction) error {
+	if err := tx.Pin(sir.blk); err != nil {
+		return err
+	}
+	defer tx.Unpin(sir.blk)
+
+	return tx.setIntWithoutLog(sir.blk, sir.offset, sir.val)
 }
 
 func (sir *SetIntRecord) ToString() string {
 	return fmt.Sprintf("<SETINT %d %s %d %d>", sir.txnum, sir.blk.FileName(), sir.blk.Number(), sir.offset)
 }
 
-func WriteSetIntRecordToLog(lm *log.LogMgr, txnum int, blk *file.BlockId, offset int, val int) int {
+func WriteSetIntRecordToLog(lm *log.LogMgr, txnum int, blk *file.BlockId, offset int, val int) (int, error) {
 	tpos := 4
 	fpos := tpos + 4
 	bpos := fpos + 4 + len(blk.FileName())
@@ -67,12 +85,24 @@ func WriteSetIntRecordToLog(lm *log.LogMgr, txnum int, blk *file.BlockId, offset
 	rec := make([]byte, vpos+4)
 	p := file.NewPageFromBytes(rec)
 
-	p.SetInt(0, SETINT)
-	p.SetInt(tpos, txnum)
-	p.SetString(fpos, blk.FileName())
-	p.SetInt(bpos, blk.Number())
-	p.SetInt(opos, offset)
-	p.SetInt(vpos, val)
+	if err := p.SetInt(0, SETINT); err != nil {
+		return 0, err
+	}
+	if err := p.SetInt(tpos, txnum); err != nil {
+		return 0, err
+	}
+	if err := p.SetString(fpos, blk.FileName()); err != nil {
+		return 0, err
+	}
+	if err := p.SetInt(bpos, blk.Number()); err != nil {
+		return 0, err
+	}
+	if err := p.SetInt(opos, offset); err != nil {
+		return 0, err
+	}
+	if err := p.SetInt(vpos, val); err != nil {
+		return 0, err
+	}
 
 	return lm.Append(rec)
 }
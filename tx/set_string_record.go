@@ -14,29 +14,44 @@ type SetStringRecord struct {
 	blk *file.BlockId
 }
 
-func NewSetStringRecord(p *file.Page) *SetStringRecord {
+func NewSetStringRecord(p *file.Page) (*SetStringRecord, error) {
 	tpos := 4
-	txnum := p.GetInt(4)
+	txnum, err := p.GetInt(4)
+	if err != nil {
+		return nil, err
+	}
 
 	fpos := tpos + 4
-	filename := p.GetString(8)
+	filename, err := p.GetString(8)
+	if err != nil {
+		return nil, err
+	}
 
 	bpos := fpos + 4 + len(filename)
-	blknum := p.GetInt(bpos)
+	blknum, err := p.GetInt(bpos)
+	if err != nil {
+		return nil, err
+	}
 	blk := file.NewBlockId(filename, blknum)
 
 	opos := bpos + 4
-	offset := p.GetInt(opos)
+	offset, err := p.GetInt(opos)
+	if err != nil {
+		return nil, err
+	}
 
 	vpos := opos + 4
-	val := p.GetString(vpos)
+	val, err := p.GetString(vpos)
+	if err != nil {
+		return nil, err
+	}
 
 	return &SetStringRecord{
 		txnum: txnum,
 		offset: offset,
 		val: val,
 		blk: blk,
-	}
+	}, nil
 }
 
 func (sir *SetStringRecord) Op() int {
@@ -47,17 +62,20 @@ func (sir *SetStringRecord) TxNumber() int {
 	return sir.txnum
 }
 
-func (sir *SetStringRecord) Undo(tx *Transaction) {
-	tx.Pin(sir.blk)
-	tx.setStringWithoutLog(sir.blk, sir.offset, sir.val)
-	tx.Unpin(sir.blk)
+func (sir *SetStringRecord) Undo(tx *Transaction) error {
+	if err := tx.Pin(sir.blk); err != nil {
+		return err
+	}
+	defer tx.Unpin(sir.blk)
+
+	return tx.setStringWithoutLog(sir.blk, sir.offset, sir.val)
 }
 
 func (sir *SetStringRecord) ToString() string {
 	return fmt.Sprintf("<SETSTRING %d %s %d %s>", sir.txnum, sir.blk.FileName(), sir.blk.Number(), sir.val)
 }
 
-func WriteSetStringRecordToLog(lm *log.LogMgr, txnum int, blk *file.BlockId, offset int, val string) int {
+func WriteSetStringRecordToLog(lm *log.LogMgr, txnum int, blk *file.BlockId, offset int, val string) (int, error) {
 	tpos := 4
 	fpos := tpos + 4
 	bpos := fpos + 4 + len(blk.FileName())
@@ -67,12 +85,24 @@ func WriteSetStringRecordToLog(lm *log.LogMgr, txnum int, blk *file.BlockId, off
 	rec := make([]byte, vpos + 4 + len(val))
 	p := file.NewPageFromBytes(rec)
 
-	p.SetInt(0, SETSTRING)
-	p.SetInt(tpos, txnum)
-	p.SetString(fpos, blk.FileName())
-	p.SetInt(bpos, blk.Number())
-	p.SetInt(opos, offset)
-	p.SetString(vpos, val)
+	if err := p.SetInt(0, SETSTRING); err != nil {
+		return 0, err
+	}
+	if err := p.SetInt(tpos, txnum); err != nil {
+		return 0, err
+	}
+	if err := p.SetString(fpos, blk.FileName()); err != nil {
+		return 0, err
+	}
+	if err := p.SetInt(bpos, blk.Number()); err != nil {
+		return 0, err
+	}
+	if err := p.SetInt(opos, offset); err != nil {
+		return 0, err
+	}
+	if err := p.SetString(vpos, val); err != nil {
+		return 0, err
+	}
 
 	return lm.Append(rec)
 }
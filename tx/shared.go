@@ -0,0 +1,28 @@
+package tx
+
+import (
+	"github.com/nfphys/simpledb-go/tx/concurrency"
+)
+
+// Shared はトランザクションどうしで共有するロック表と版の置き場。
+// BufferMgr と一緒に 1 つ作り、その BufferMgr を使うすべての NewTransaction に渡す。
+type Shared struct {
+	lockTable *concurrency.LockTable
+	versionStore *concurrency.VersionStore
+}
+
+// NewShared は opts で設定したロック表と、空の版の置き場を作る。
+func NewShared(opts ...concurrency.Option) *Shared {
+	return &Shared{
+		lockTable: concurrency.NewLockTable(opts...),
+		versionStore: concurrency.NewVersionStore(),
+	}
+}
+
+func (s *Shared) LockTable() *concurrency.LockTable {
+	return s.lockTable
+}
+
+func (s *Shared) VersionStore() *concurrency.VersionStore {
+	return s.versionStore
+}
@@ -11,10 +11,14 @@ type StartRecord struct {
 	txnum int
 }
 
-func NewStartRecord(p *file.Page) *StartRecord {
-	return &StartRecord{
-		txnum: p.GetInt(4),
+func NewStartRecord(p *file.Page) (*StartRecord, error) {
+	txnum, err := p.GetInt(4)
+	if err != nil {
+		return nil, err
 	}
+	return &StartRecord{
+		txnum: txnum,
+	}, nil
 }
 
 func (sr *StartRecord) Op() int {
@@ -25,18 +29,23 @@ func (sr *StartRecord) TxNumber() int {
 	return sr.txnum
 }
 
-func (sr *StartRecord) Undo(tx *Transaction) {
+func (sr *StartRecord) Undo(tx *Transaction) error {
 	// No undo operation for START record
+	return nil
 }
 
 func (sr *StartRecord) ToString() string {
 	return fmt.Sprintf("<START %d>", sr.txnum)
 }
 
-func WriteStartRecordToLog(lm *log.LogMgr, txnum int) int {
+func WriteStartRecordToLog(lm *log.LogMgr, txnum int) (int, error) {
 	rec := make([]byte, 8)
 	p := file.NewPageFromBytes(rec)
-	p.SetInt(0, START)
-	p.SetInt(4, txnum)
+	if err := p.SetInt(0, START); err != nil {
+		return 0, err
+	}
+	if err := p.SetInt(4, txnum); err != nil {
+		return 0, err
+	}
 	return lm.Append(rec)
 }
@@ -1,11 +1,16 @@
 package tx
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"sync"
 
 	"github.com/nfphys/simpledb-go/buffer"
 	"github.com/nfphys/simpledb-go/file"
 	"github.com/nfphys/simpledb-go/log"
+	"github.com/nfphys/simpledb-go/tx/concurrency"
 )
 
 var (
@@ -13,50 +18,199 @@ var (
 	mu sync.Mutex
 )
 
+var (
+	ErrBlockNotPinned = errors.New("block not pinned")
+	ErrSavepointNotFound = errors.New("savepoint not found")
+)
+
 type Transaction struct {
 	fm *file.FileMgr
 	lm *log.LogMgr
 	bm *buffer.BufferMgr
 	txnum int
+	cm *concurrency.ConcurrencyMgr
+	vs *concurrency.VersionStore
+	snapshot bool
+	ts int64 // スナップショットの時刻
+	savepoints []savepoint // 作った順
 	mybuffers *BufferList
+	ended bool // コミットかロールバックが終わり、ロックを外した
 }
 
-func NewTransaction(fm *file.FileMgr, lm *log.LogMgr, bm *buffer.BufferMgr) *Transaction {
+// savepoint は名前と、ログに書いたセーブポイントのレコードの LSN。
+type savepoint struct {
+	name string
+	lsn int
+}
+
+// NewTransaction はトランザクションを始める。ロックと版は shared のものを使うので、
+// 同じ BufferMgr を使うトランザクションには同じ shared を渡すこと。
+func NewTransaction(fm *file.FileMgr, lm *log.LogMgr, bm *buffer.BufferMgr, shared *Shared, opts ...Option) (*Transaction, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
+	cfg := config{level: concurrency.Serializable}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	txnum := nextTxNum
 	nextTxNum++
 
-	WriteStartRecordToLog(lm, txnum)
+	if _, err := WriteStartRecordToLog(lm, txnum); err != nil {
+		return nil, fmt.Errorf("tx %d: start: %w", txnum, err)
+	}
 
-	return &Transaction{
+	tx := &Transaction{
 		fm: fm,
 		lm: lm,
 		bm: bm,
 		txnum: txnum,
+		cm: concurrency.NewConcurrencyMgr(shared.lockTable, txnum, cfg.level),
+		vs: shared.versionStore,
+		snapshot: cfg.snapshot,
 		mybuffers: NewBufferList(bm),
 	}
+	if tx.snapshot {
+		tx.ts = tx.vs.Begin(txnum)
+	}
+	return tx, nil
 }
 
-func (tx *Transaction) Commit() {
-	// TODO: implement concurrency control
-	tx.mybuffers.FlushAll()
-	lsn := WriteCommitRecordToLog(tx.lm, tx.txnum)
-	tx.lm.Flush(lsn)
-	tx.mybuffers.UnpinAll()
+// Commit はトランザクションをコミットし、持っているロックをすべて外す。
+// 途中で失敗したら変更を取り消してからロックとピンを外し、最初のエラーを返す。
+// 取り消しにも失敗したときは、書きかけのページを見せないよう xlock を持ったままにする。
+func (tx *Transaction) Commit() error {
+	if err := tx.commit(); err != nil {
+		if uerr := tx.doRollback(-1); uerr != nil {
+			tx.mybuffers.UnpinAll()
+			return errors.Join(err, fmt.Errorf("tx %d: commit: undo: %w", tx.txnum, uerr))
+		}
+		tx.finish(false)
+		return err
+	}
+	tx.finish(true)
+	return nil
+}
+
+func (tx *Transaction) commit() error {
+	// すでに Unpin したバッファも含め、このトランザクションが変更したページを書く
+	if err := tx.bm.FlushAll(tx.txnum); err != nil {
+		return fmt.Errorf("tx %d: commit: %w", tx.txnum, err)
+	}
+	lsn, err := WriteCommitRecordToLog(tx.lm, tx.txnum)
+	if err != nil {
+		return fmt.Errorf("tx %d: commit: %w", tx.txnum, err)
+	}
+	if err := tx.lm.Flush(lsn); err != nil {
+		return fmt.Errorf("tx %d: commit: %w", tx.txnum, err)
+	}
+	return nil
+}
+
+// Rollback はトランザクションの変更を取り消し、持っているロックをすべて外す。
+// ロックを待ちきれずに ErrLockAbort が返ったときも Rollback すること。
+// 取り消した後の書き出しに失敗しても、ロックとピンは外して最初のエラーを返す。
+// 取り消しの途中で失敗したときは、xlock を持ったままにするので、もう一度 Rollback すること。
+// すでに終わったトランザクションでは何もしない。
+func (tx *Transaction) Rollback() error {
+	if tx.ended {
+		return nil
+	}
+	if err := tx.doRollback(-1); err != nil {
+		tx.mybuffers.UnpinAll()
+		return fmt.Errorf("tx %d: rollback: %w", tx.txnum, err)
+	}
+	defer tx.finish(false)
+
+	if err := tx.bm.FlushAll(tx.txnum); err != nil {
+		return fmt.Errorf("tx %d: rollback: %w", tx.txnum, err)
+	}
+	if _, err := WriteRollbackRecordToLog(tx.lm, tx.txnum); err != nil {
+		return fmt.Errorf("tx %d: rollback: %w", tx.txnum, err)
+	}
+	return nil
 }
 
-func (tx *Transaction) Rollback() {
-	// TODO: implement concurrency control
-	tx.doRollback()
-	WriteRollbackRecordToLog(tx.lm, tx.txnum)
+// finish はトランザクションの版を確定か破棄し、ロックとピンをすべて外す。
+// コミットに失敗したときは、書いた版を他のトランザクションに見せない。
+func (tx *Transaction) finish(committed bool) {
+	if committed {
+		tx.vs.Commit(tx.txnum)
+	} else {
+		tx.vs.Abort(tx.txnum)
+	}
+	tx.cm.Release()
 	tx.mybuffers.UnpinAll()
+	tx.ended = true
+}
+
+func (tx *Transaction) TxNum() int {
+	return tx.txnum
+}
+
+// Savepoint は今の状態に name という名前をつけ、RollbackTo で戻れるようにする。
+// 同じ名前のセーブポイントがあれば置き換える。
+func (tx *Transaction) Savepoint(name string) error {
+	lsn, err := WriteSavepointRecordToLog(tx.lm, tx.txnum, name)
+	if err != nil {
+		return fmt.Errorf("tx %d: savepoint %s: %w", tx.txnum, name, err)
+	}
+	if i := tx.findSavepoint(name); i >= 0 {
+		tx.savepoints = append(tx.savepoints[:i], tx.savepoints[i+1:]...)
+	}
+	tx.savepoints = append(tx.savepoints, savepoint{name: name, lsn: lsn})
+	return nil
+}
+
+// RollbackTo はセーブポイント name より後の変更を取り消す。トランザクションは続き、
+// ロックも持ったまま。name より後に作ったセーブポイントはなくなるが、name は残る。
+func (tx *Transaction) RollbackTo(name string) error {
+	i := tx.findSavepoint(name)
+	if i < 0 {
+		return fmt.Errorf("tx %d: rollback to %s: %w", tx.txnum, name, ErrSavepointNotFound)
+	}
+	if err := tx.doRollback(tx.savepoints[i].lsn); err != nil {
+		return fmt.Errorf("tx %d: rollback to %s: %w", tx.txnum, name, err)
+	}
+	tx.savepoints = tx.savepoints[:i+1]
+	return nil
 }
 
-func (tx *Transaction) doRollback() {
-	for bytes := range tx.lm.Iterator() {
-		rec := CreateLogRecord(bytes)
+// Release はセーブポイント name と、それより後に作ったセーブポイントをなくす。変更はそのまま残る。
+func (tx *Transaction) Release(name string) error {
+	i := tx.findSavepoint(name)
+	if i < 0 {
+		return fmt.Errorf("tx %d: release %s: %w", tx.txnum, name, ErrSavepointNotFound)
+	}
+	tx.savepoints = tx.savepoints[:i]
+	return nil
+}
+
+func (tx *Transaction) findSavepoint(name string) int {
+	for i, sp := range tx.savepoints {
+		if sp.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// doRollback はこのトランザクションのログレコードを新しいものから undo する。
+// savepoint が LSN ならそのセーブポイントのレコードで、負なら START レコードで止まる。
+func (tx *Transaction) doRollback(savepoint int) error {
+	for r, err := range tx.lm.Records() {
+		if err != nil {
+			return err
+		}
+		if r.LSN == savepoint {
+			break
+		}
+
+		rec, err := CreateLogRecord(r.Data)
+		if err != nil {
+			return err
+		}
 		if rec.TxNumber() != tx.txnum {
 			continue
 		}
@@ -65,54 +219,206 @@ func (tx *Transaction) doRollback() {
 			break
 		}
 
-		rec.Undo(tx)
+		if err := rec.Undo(tx); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
+// Pin は blk をピンする。空きバッファを待つ時間は BufferMgr の既定に従う。
 func (tx *Transaction) Pin(blk *file.BlockId) error {
-	return tx.mybuffers.Pin(blk)
+	return tx.PinContext(context.Background(), blk)
+}
+
+// PinContext は ctx が終わるまで空きバッファを待つ Pin。
+func (tx *Transaction) PinContext(ctx context.Context, blk *file.BlockId) error {
+	if err := tx.mybuffers.PinContext(ctx, blk); err != nil {
+		return fmt.Errorf("tx %d: pin %v: %w", tx.txnum, blk, err)
+	}
+	return nil
 }
 
 func (tx *Transaction) Unpin(blk *file.BlockId) {
 	tx.mybuffers.Unpin(blk)
 }
 
-func (tx *Transaction) GetInt(blk *file.BlockId, offset int) int {
-	// TODO: implement concurrency control
-	buffer := tx.mybuffers.GetBuffer(blk)
+func (tx *Transaction) GetInt(blk *file.BlockId, offset int) (int, error) {
+	buffer, err := tx.getBuffer(blk)
+	if err != nil {
+		return 0, err
+	}
+	var val int
+	if tx.snapshot {
+		tx.vs.View(blk, tx.txnum, tx.ts, buffer.Contents(), func(p *file.Page) {
+			val, err = p.GetInt(offset)
+		})
+	} else {
+		release, lerr := tx.cm.ReadLock(blk)
+		if lerr != nil {
+			return 0, fmt.Errorf("tx %d: get int %v: %w", tx.txnum, blk, lerr)
+		}
+		val, err = buffer.Contents().GetInt(offset)
+		release()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("tx %d: get int %v: %w", tx.txnum, blk, err)
+	}
+	return val, nil
+}
 
-	return buffer.Contents().GetInt(offset)
+func (tx *Transaction) GetString(blk *file.BlockId, offset int) (string, error) {
+	buffer, err := tx.getBuffer(blk)
+	if err != nil {
+		return "", err
+	}
+	var val string
+	if tx.snapshot {
+		tx.vs.View(blk, tx.txnum, tx.ts, buffer.Contents(), func(p *file.Page) {
+			val, err = p.GetString(offset)
+		})
+	} else {
+		release, lerr := tx.cm.ReadLock(blk)
+		if lerr != nil {
+			return "", fmt.Errorf("tx %d: get string %v: %w", tx.txnum, blk, lerr)
+		}
+		val, err = buffer.Contents().GetString(offset)
+		release()
+	}
+	if err != nil {
+		return "", fmt.Errorf("tx %d: get string %v: %w", tx.txnum, blk, err)
+	}
+	return val, nil
 }
 
-func (tx *Transaction) GetString(blk *file.BlockId, offset int) string {
-	// TODO: implement concurrency control
-	buffer := tx.mybuffers.GetBuffer(blk)
+func (tx *Transaction) SetInt(blk *file.BlockId, offset int, val int) error {
+	buffer, err := tx.getBuffer(blk)
+	if err != nil {
+		return err
+	}
+	if err := tx.xlock(blk); err != nil {
+		return fmt.Errorf("tx %d: set int %v: %w", tx.txnum, blk, err)
+	}
+	oldval, err := tx.GetInt(blk, offset)
+	if err != nil {
+		return err
+	}
+	// ページに格納できない値はログに書く前に弾く
+	if val < math.MinInt32 || val > math.MaxInt32 {
+		return fmt.Errorf("tx %d: set int %v: %d: %w", tx.txnum, blk, val, file.ErrValueOutOfRange)
+	}
+	lsn, err := WriteSetIntRecordToLog(tx.lm, tx.txnum, blk, offset, oldval)
+	if err != nil {
+		return fmt.Errorf("tx %d: set int %v: %w", tx.txnum, blk, err)
+	}
+	// スナップショットのために変更前のページを残す
+	tx.vs.BeforeWrite(blk, tx.txnum, buffer.Contents())
+	return tx.setInt(blk, offset, val, lsn)
+}
 
-	return buffer.Contents().GetString(offset)
+func (tx *Transaction) SetString(blk *file.BlockId, offset int, val string) error {
+	buffer, err := tx.getBuffer(blk)
+	if err != nil {
+		return err
+	}
+	if err := tx.xlock(blk); err != nil {
+		return fmt.Errorf("tx %d: set string %v: %w", tx.txnum, blk, err)
+	}
+	oldval, err := tx.GetString(blk, offset)
+	if err != nil {
+		return err
+	}
+	// ページに収まらない値はログに書く前に弾く
+	if offset+file.INT_BYTES+len(val) > tx.fm.BlockSize() {
+		return fmt.Errorf("tx %d: set string %v: %d bytes at offset %d: %w", tx.txnum, blk, len(val), offset, file.ErrPageOverflow)
+	}
+	lsn, err := WriteSetStringRecordToLog(tx.lm, tx.txnum, blk, offset, oldval)
+	if err != nil {
+		return fmt.Errorf("tx %d: set string %v: %w", tx.txnum, blk, err)
+	}
+	// スナップショットのために変更前のページを残す
+	tx.vs.BeforeWrite(blk, tx.txnum, buffer.Contents())
+	return tx.setString(blk, offset, val, lsn)
+}
+
+// Size は filename のブロック数を返す。SERIALIZABLE では最後まで Append と排他になるので、
+// 数え直してもブロックが増えていることはない。
+func (tx *Transaction) Size(filename string) (int, error) {
+	if !tx.snapshot {
+		release, err := tx.cm.ReadLock(concurrency.EndOfFile(filename))
+		if err != nil {
+			return 0, fmt.Errorf("tx %d: size %s: %w", tx.txnum, filename, err)
+		}
+		defer release()
+	}
+	n, err := tx.fm.Length(filename)
+	if err != nil {
+		return 0, fmt.Errorf("tx %d: size %s: %w", tx.txnum, filename, err)
+	}
+	return n, nil
 }
 
-func (tx *Transaction) SetInt(blk *file.BlockId, offset int, val int) {
-	// TODO: implement concurrency control
-	oldval := tx.GetInt(blk, offset)
-	WriteSetIntRecordToLog(tx.lm, tx.txnum, blk, offset, oldval)
-	tx.setIntWithoutLog(blk, offset, val)
+// Append は filename の末尾にゼロで埋めたブロックを足して返す。足したブロックはロールバックしても残る。
+func (tx *Transaction) Append(filename string) (*file.BlockId, error) {
+	if err := tx.cm.XLock(concurrency.EndOfFile(filename)); err != nil {
+		return nil, fmt.Errorf("tx %d: append %s: %w", tx.txnum, filename, err)
+	}
+	blk, err := tx.fm.Append(filename)
+	if err != nil {
+		return nil, fmt.Errorf("tx %d: append %s: %w", tx.txnum, filename, err)
+	}
+	return blk, nil
 }
 
-func (tx *Transaction) SetString(blk *file.BlockId, offset int, val string) {
-	// TODO: implement concurrency control
-	oldval := tx.GetString(blk, offset)
-	WriteSetStringRecordToLog(tx.lm, tx.txnum, blk, offset, oldval)
-	tx.setStringWithoutLog(blk, offset, val)
+// xlock は blk の xlock を取る。スナップショットを使うトランザクションでは、
+// スナップショットの後に他のトランザクションが blk をコミットしていれば書かせない。
+func (tx *Transaction) xlock(blk *file.BlockId) error {
+	if err := tx.cm.XLock(blk); err != nil {
+		return err
+	}
+	if tx.snapshot {
+		return tx.vs.CheckWrite(blk, tx.ts)
+	}
+	return nil
 }
 
-func (tx *Transaction) setIntWithoutLog(blk *file.BlockId, offset int, val int) {
-	buffer := tx.mybuffers.GetBuffer(blk)
-	buffer.Contents().SetInt(offset, val)
-	buffer.SetModified()
+func (tx *Transaction) setIntWithoutLog(blk *file.BlockId, offset int, val int) error {
+	return tx.setInt(blk, offset, val, -1)
 }
 
-func (tx *Transaction) setStringWithoutLog(blk *file.BlockId, offset int, val string) {
+func (tx *Transaction) setStringWithoutLog(blk *file.BlockId, offset int, val string) error {
+	return tx.setString(blk, offset, val, -1)
+}
+
+// setInt はページを書き換え、変更を記録したログレコードの LSN をバッファに残す。
+func (tx *Transaction) setInt(blk *file.BlockId, offset int, val int, lsn int) error {
+	buffer, err := tx.getBuffer(blk)
+	if err != nil {
+		return err
+	}
+	if err := buffer.Contents().SetInt(offset, val); err != nil {
+		return fmt.Errorf("tx %d: set int %v: %w", tx.txnum, blk, err)
+	}
+	buffer.SetModified(tx.txnum, lsn)
+	return nil
+}
+
+func (tx *Transaction) setString(blk *file.BlockId, offset int, val string, lsn int) error {
+	buffer, err := tx.getBuffer(blk)
+	if err != nil {
+		return err
+	}
+	if err := buffer.Contents().SetString(offset, val); err != nil {
+		return fmt.Errorf("tx %d: set string %v: %w", tx.txnum, blk, err)
+	}
+	buffer.SetModified(tx.txnum, lsn)
+	return nil
+}
+
+func (tx *Transaction) getBuffer(blk *file.BlockId) (*buffer.Buffer, error) {
 	buffer := tx.mybuffers.GetBuffer(blk)
-	buffer.Contents().SetString(offset, val)
-	buffer.SetModified()
+	if buffer == nil {
+		return nil, fmt.Errorf("tx %d: %v: %w", tx.txnum, blk, ErrBlockNotPinned)
+	}
+	return buffer, nil
 }
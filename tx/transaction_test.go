@@ -1,50 +1,67 @@
 package tx_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/nfphys/simpledb-go/buffer"
 	"github.com/nfphys/simpledb-go/file"
 	"github.com/nfphys/simpledb-go/log"
 	"github.com/nfphys/simpledb-go/tx"
+	"github.com/nfphys/simpledb-go/tx/concurrency"
 )
 
-func setup(blocksize int) *file.FileMgr {
-	dbDir := filepath.Join(os.TempDir(), "testdb")
-	os.RemoveAll(dbDir)
-	return file.NewFileMgr(dbDir, blocksize)
+func setup(t *testing.T, blocksize int) *file.FileMgr {
+	fm, err := file.NewFileMgrWithStore(file.NewMemStore(), blocksize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return fm
 }
 
 func cleanup(fm *file.FileMgr) {
 	fm.Close()
-	os.RemoveAll(filepath.Join(os.TempDir(), "testdb"))
 }
 
 func TestPinAndSetInt(t *testing.T) {
 	// Given
 	blocksize := 4096
-	fm := setup(blocksize)
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
-	lm := log.NewLogMgr(fm, "logfile")
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
 
 	blk := file.NewBlockId("testfile", 0)
 
-	tx1 := tx.NewTransaction(fm, lm, bm)
+	tx1, err := tx.NewTransaction(fm, lm, bm, shared)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
 	// When
-	err := tx1.Pin(blk)
+	err = tx1.Pin(blk)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	err = tx1.SetInt(blk, 0, 42)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
-	tx1.SetInt(blk, 0, 42)
 
 	// Then
-	i := tx1.GetInt(blk, 0)
+	i, err := tx1.GetInt(blk, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	if i != 42 {
 		t.Errorf("Expected 42, got %d", i)
 	}
@@ -53,25 +70,38 @@ func TestPinAndSetInt(t *testing.T) {
 func TestPinAndSetString(t *testing.T) {
 	// Given
 	blocksize := 4096
-	fm := setup(blocksize)
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
-	lm := log.NewLogMgr(fm, "logfile")
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
 
 	blk := file.NewBlockId("testfile", 0)
 
-	tx1 := tx.NewTransaction(fm, lm, bm)
+	tx1, err := tx.NewTransaction(fm, lm, bm, shared)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
 	// When
-	err := tx1.Pin(blk)
+	err = tx1.Pin(blk)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	err = tx1.SetString(blk, 0, "hello")
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
-	tx1.SetString(blk, 0, "hello")
 
 	// Then
-	s := tx1.GetString(blk, 0)
+	s, err := tx1.GetString(blk, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	if s != "hello" {
 		t.Errorf("Expected 'hello', got '%s'", s)
 	}
@@ -80,11 +110,15 @@ func TestPinAndSetString(t *testing.T) {
 func TestCommit(t *testing.T) {
 	// Given
 	blocksize := 4096
-	fm := setup(blocksize)
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
-	lm := log.NewLogMgr(fm, "logfile")
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
 
 	blk := file.NewBlockId("testfile", 0)
 	p := file.NewPage(blocksize)
@@ -92,8 +126,11 @@ func TestCommit(t *testing.T) {
 	p.SetString(100, "")
 	fm.Write(blk, p)
 
-	tx1 := tx.NewTransaction(fm, lm, bm)
-	err := tx1.Pin(blk)
+	tx1, err := tx.NewTransaction(fm, lm, bm, shared)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	err = tx1.Pin(blk)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -101,23 +138,33 @@ func TestCommit(t *testing.T) {
 	tx1.SetString(blk, 100, "hello")
 
 	// When
-	tx1.Commit()
+	err = tx1.Commit()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
 	// Then
 	// Check if the block is flushed to disk
 	p = file.NewPage(blocksize)
 	fm.Read(blk, p)
-	if p.GetInt(0) != 42 {
-		t.Errorf("Expected 42, got %d", p.GetInt(0))
+	if v, _ := p.GetInt(0); v != 42  {
+		t.Errorf("Expected 42, got %d", v)
 	}
-	if p.GetString(100) != "hello" {
-		t.Errorf("Expected 'hello', got '%s'", p.GetString(100))
+	if s, _ := p.GetString(100); s != "hello" {
+		t.Errorf("Expected 'hello', got '%s'", s)
 	}
 
 	// Check if the log is created
 	recs := []tx.LogRecord{}
-	for rec := range lm.Iterator() {
-		recs = append(recs, tx.CreateLogRecord(rec))
+	for rec, err := range lm.Iterator() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		r, err := tx.CreateLogRecord(rec)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		recs = append(recs, r)
 	}
 	fmt.Println(recs[0].Op(), recs[1].Op())
 	if len(recs) != 4 {
@@ -141,11 +188,15 @@ func TestCommit(t *testing.T) {
 func TestRollback(t *testing.T) {
 	// Given
 	blocksize := 4096
-	fm := setup(blocksize)
+	fm := setup(t, blocksize)
 	defer cleanup(fm)
 
-	lm := log.NewLogMgr(fm, "logfile")
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
 
 	blk := file.NewBlockId("testfile", 0)
 	p := file.NewPage(blocksize)
@@ -153,8 +204,11 @@ func TestRollback(t *testing.T) {
 	p.SetString(100, "")
 	fm.Write(blk, p)
 
-	tx1 := tx.NewTransaction(fm, lm, bm)
-	err := tx1.Pin(blk)
+	tx1, err := tx.NewTransaction(fm, lm, bm, shared)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	err = tx1.Pin(blk)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -162,23 +216,33 @@ func TestRollback(t *testing.T) {
 	tx1.SetString(blk, 100, "hello")
 
 	// When
-	tx1.Rollback()
+	err = tx1.Rollback()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
 	// Then
 	// Check if the block is rolled back
 	p = file.NewPage(blocksize)
 	fm.Read(blk, p)
-	if p.GetInt(0) != 0 {
-		t.Errorf("Expected 0, got %d", p.GetInt(0))
+	if v, _ := p.GetInt(0); v != 0  {
+		t.Errorf("Expected 0, got %d", v)
 	}
-	if p.GetString(100) != "" {
-		t.Errorf("Expected '', got '%s'", p.GetString(100))
+	if s, _ := p.GetString(100); s != "" {
+		t.Errorf("Expected '', got '%s'", s)
 	}
 
 	// Check if the log is created
 	recs := []tx.LogRecord{}
-	for rec := range lm.Iterator() {
-		recs = append(recs, tx.CreateLogRecord(rec))
+	for rec, err := range lm.Iterator() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		r, err := tx.CreateLogRecord(rec)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		recs = append(recs, r)
 	}
 	if len(recs) != 4 {
 		t.Errorf("Expected 3 log records, got %d", len(recs))
@@ -197,3 +261,911 @@ func TestRollback(t *testing.T) {
 		t.Errorf("Expected START, got %d", recs[3].Op())
 	}
 }
+
+func TestGetIntWithoutPin(t *testing.T) {
+	// Given
+	blocksize := 4096
+	fm := setup(t, blocksize)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
+
+	blk := file.NewBlockId("testfile", 0)
+
+	tx1, err := tx.NewTransaction(fm, lm, bm, shared)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	_, err = tx1.GetInt(blk, 0)
+
+	// Then
+	if !errors.Is(err, tx.ErrBlockNotPinned) {
+		t.Errorf("Expected ErrBlockNotPinned, got %v", err)
+	}
+}
+
+func TestSetStringTooLarge(t *testing.T) {
+	// Given
+	blocksize := 64
+	fm := setup(t, blocksize)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
+
+	blk := file.NewBlockId("testfile", 0)
+
+	tx1, err := tx.NewTransaction(fm, lm, bm, shared)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := tx1.Pin(blk); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	err = tx1.SetString(blk, 40, "this string does not fit")
+
+	// Then
+	if !errors.Is(err, file.ErrPageOverflow) {
+		t.Errorf("Expected ErrPageOverflow, got %v", err)
+	}
+}
+
+func TestSetIntTooLarge(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
+	blk := file.NewBlockId("testfile", 0)
+
+	tx1, _ := tx.NewTransaction(fm, lm, bm, shared)
+	tx1.Pin(blk)
+	before := lm.LatestLSN()
+
+	// When
+	err = tx1.SetInt(blk, 0, 1<<33+7)
+
+	// Then
+	if !errors.Is(err, file.ErrValueOutOfRange) {
+		t.Errorf("Expected ErrValueOutOfRange, got %v", err)
+	}
+	if lm.LatestLSN() != before {
+		t.Errorf("Expected no log record, got LSN %d after %d", lm.LatestLSN(), before)
+	}
+	if i, _ := tx1.GetInt(blk, 0); i != 0 {
+		t.Errorf("Expected 0, got %d", i)
+	}
+}
+
+func TestRollbackWithLogRecordsLargerThanBlock(t *testing.T) {
+	// Given
+	blocksize := 64
+	fm := setup(t, blocksize)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
+
+	blk := file.NewBlockId("testfile", 0)
+	old := "a string that is longer than the free space in a log block"
+	p := file.NewPage(blocksize)
+	p.SetString(0, old)
+	fm.Write(blk, p)
+
+	tx1, err := tx.NewTransaction(fm, lm, bm, shared)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := tx1.Pin(blk); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := tx1.SetString(blk, 0, "short"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	err = tx1.Rollback()
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tx2, err := tx.NewTransaction(fm, lm, bm, shared)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := tx2.Pin(blk); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if s, _ := tx2.GetString(blk, 0); s != old {
+		t.Errorf("Expected '%s', got '%s'", old, s)
+	}
+}
+
+func TestCreateLogRecordUnknownType(t *testing.T) {
+	// Given
+	rec := []byte{99, 0, 0, 0}
+
+	// When
+	_, err := tx.CreateLogRecord(rec)
+
+	// Then
+	if !errors.Is(err, tx.ErrUnknownLogRecord) {
+		t.Errorf("Expected ErrUnknownLogRecord, got %v", err)
+	}
+}
+
+func TestEvictedPageIsLoggedFirst(t *testing.T) {
+	// Given
+	blocksize := 4096
+	fm := setup(t, blocksize)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 1)
+	shared := tx.NewShared()
+
+	blk0 := file.NewBlockId("testfile", 0)
+	blk1 := file.NewBlockId("testfile", 1)
+
+	tx1, err := tx.NewTransaction(fm, lm, bm, shared)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tx1.Pin(blk0)
+	if err := tx1.SetString(blk0, 0, "dirty"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lsn := lm.LatestLSN()
+	tx1.Unpin(blk0)
+	if lm.FlushedLSN() >= lsn {
+		t.Fatalf("Expected set string record not yet flushed, got flushed LSN %d", lm.FlushedLSN())
+	}
+
+	// When
+	err = tx1.Pin(blk1) // blk0 のページがディスクに書かれる
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if lm.FlushedLSN() < lsn {
+		t.Errorf("Expected log flushed to LSN %d, got %d", lsn, lm.FlushedLSN())
+	}
+	p := file.NewPage(blocksize)
+	fm.Read(blk0, p)
+	if s, _ := p.GetString(0); s != "dirty" {
+		t.Errorf("Expected 'dirty' on disk, got '%s'", s)
+	}
+}
+
+func TestPinContextCanceled(t *testing.T) {
+	// Given
+	blocksize := 4096
+	fm := setup(t, blocksize)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 1)
+	shared := tx.NewShared()
+
+	tx1, err := tx.NewTransaction(fm, lm, bm, shared)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := tx1.Pin(file.NewBlockId("testfile", 0)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tx2, err := tx.NewTransaction(fm, lm, bm, shared)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// When
+	err = tx2.PinContext(ctx, file.NewBlockId("testfile", 1))
+
+	// Then
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context canceled, got %v", err)
+	}
+	if _, err := tx2.GetInt(file.NewBlockId("testfile", 1), 0); !errors.Is(err, tx.ErrBlockNotPinned) {
+		t.Errorf("Expected block not pinned, got %v", err)
+	}
+}
+
+func TestCommitFlushesUnpinnedBuffers(t *testing.T) {
+	// Given
+	blocksize := 4096
+	fm := setup(t, blocksize)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
+
+	blk := file.NewBlockId("testfile", 0)
+	tx1, err := tx.NewTransaction(fm, lm, bm, shared)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tx1.Pin(blk)
+	if err := tx1.SetInt(blk, 0, 42); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tx1.Unpin(blk)
+
+	// When
+	err = tx1.Commit()
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	p := file.NewPage(blocksize)
+	fm.Read(blk, p)
+	if v, _ := p.GetInt(0); v != 42  {
+		t.Errorf("Expected 42 on disk after commit, got %d", v)
+	}
+}
+
+func TestUncommittedWriteBlocksReader(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared(concurrency.WithMaxWait(10 * time.Millisecond))
+	blk := file.NewBlockId("testfile", 0)
+
+	writer, _ := tx.NewTransaction(fm, lm, bm, shared)
+	reader, _ := tx.NewTransaction(fm, lm, bm, shared)
+	writer.Pin(blk)
+	reader.Pin(blk)
+	if err := writer.SetInt(blk, 0, 42); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	_, err = reader.GetInt(blk, 0)
+
+	// Then
+	if !errors.Is(err, concurrency.ErrLockAbort) {
+		t.Fatalf("Expected ErrLockAbort, got %v", err)
+	}
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	i, err := reader.GetInt(blk, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if i != 42 {
+		t.Errorf("Expected 42, got %d", i)
+	}
+}
+
+func TestConcurrentIncrements(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 8)
+	shared := tx.NewShared(concurrency.WithMaxWait(5 * time.Millisecond))
+	blk := file.NewBlockId("testfile", 0)
+
+	workers, increments := 4, 20
+	increment := func() error {
+		for {
+			tx1, err := tx.NewTransaction(fm, lm, bm, shared)
+			if err != nil {
+				return err
+			}
+			if err := tx1.Pin(blk); err != nil {
+				return err
+			}
+			// slock からの格上げで互いに待ち合うと、どちらかが ErrDeadlock で中断される
+			i, err := tx1.GetInt(blk, 0)
+			if err == nil {
+				err = tx1.SetInt(blk, 0, i+1)
+			}
+			if errors.Is(err, concurrency.ErrLockAbort) {
+				if err := tx1.Rollback(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			return tx1.Commit()
+		}
+	}
+
+	// When
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				if err := increment(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// Then
+	for err := range errs {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tx1, _ := tx.NewTransaction(fm, lm, bm, shared)
+	tx1.Pin(blk)
+	i, err := tx1.GetInt(blk, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if i != workers*increments {
+		t.Errorf("Expected %d, got %d", workers*increments, i)
+	}
+}
+
+func TestSnapshotReads(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared(concurrency.WithMaxWait(10 * time.Millisecond))
+	blk := file.NewBlockId("testfile", 0)
+
+	tx1, _ := tx.NewTransaction(fm, lm, bm, shared)
+	tx1.Pin(blk)
+	tx1.SetInt(blk, 0, 1)
+	tx1.Commit()
+
+	snapshot, _ := tx.NewTransaction(fm, lm, bm, shared, tx.WithSnapshot())
+	snapshot.Pin(blk)
+	writer, _ := tx.NewTransaction(fm, lm, bm, shared)
+	writer.Pin(blk)
+	if err := writer.SetInt(blk, 0, 2); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	uncommitted, err := snapshot.GetInt(blk, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	committed, err := snapshot.GetInt(blk, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Then
+	if uncommitted != 1 || committed != 1 {
+		t.Errorf("Expected snapshot to see 1, got %d and %d", uncommitted, committed)
+	}
+	if err := snapshot.Commit(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if shared.VersionStore().Versions() != 0 {
+		t.Errorf("Expected old versions to be collected, got %d", shared.VersionStore().Versions())
+	}
+	later, _ := tx.NewTransaction(fm, lm, bm, shared, tx.WithSnapshot())
+	later.Pin(blk)
+	if i, _ := later.GetInt(blk, 0); i != 2 {
+		t.Errorf("Expected later snapshot to see 2, got %d", i)
+	}
+}
+
+func TestSnapshotFirstCommitterWins(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
+	blk := file.NewBlockId("testfile", 0)
+
+	tx1, _ := tx.NewTransaction(fm, lm, bm, shared, tx.WithSnapshot())
+	tx2, _ := tx.NewTransaction(fm, lm, bm, shared, tx.WithSnapshot())
+	tx1.Pin(blk)
+	tx2.Pin(blk)
+	if err := tx1.SetString(blk, 0, "first"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if s, _ := tx1.GetString(blk, 0); s != "first" {
+		t.Errorf("Expected to read own write 'first', got '%s'", s)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	err = tx2.SetString(blk, 0, "second")
+
+	// Then
+	if !errors.Is(err, concurrency.ErrWriteConflict) {
+		t.Fatalf("Expected ErrWriteConflict, got %v", err)
+	}
+	if err := tx2.Rollback(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tx3, _ := tx.NewTransaction(fm, lm, bm, shared)
+	tx3.Pin(blk)
+	if s, _ := tx3.GetString(blk, 0); s != "first" {
+		t.Errorf("Expected 'first', got '%s'", s)
+	}
+}
+
+func TestRollbackToSavepoint(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
+	blk := file.NewBlockId("testfile", 0)
+
+	tx1, _ := tx.NewTransaction(fm, lm, bm, shared)
+	tx1.Pin(blk)
+	tx1.SetInt(blk, 0, 1)
+	if err := tx1.Savepoint("a"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tx1.SetInt(blk, 0, 2)
+	tx1.SetString(blk, 4, "after a")
+	tx1.Savepoint("b")
+	tx1.SetInt(blk, 0, 3)
+
+	// When
+	err = tx1.RollbackTo("a")
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if i, _ := tx1.GetInt(blk, 0); i != 1 {
+		t.Errorf("Expected 1, got %d", i)
+	}
+	if s, _ := tx1.GetString(blk, 4); s != "" {
+		t.Errorf("Expected '', got '%s'", s)
+	}
+	if err := tx1.RollbackTo("b"); !errors.Is(err, tx.ErrSavepointNotFound) {
+		t.Errorf("Expected ErrSavepointNotFound for b, got %v", err)
+	}
+
+	// セーブポイントは残るので、やり直してもう一度戻れる
+	tx1.SetInt(blk, 0, 4)
+	if err := tx1.RollbackTo("a"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tx1.SetInt(blk, 0, 5)
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tx2, _ := tx.NewTransaction(fm, lm, bm, shared)
+	tx2.Pin(blk)
+	if i, _ := tx2.GetInt(blk, 0); i != 5 {
+		t.Errorf("Expected 5, got %d", i)
+	}
+}
+
+func TestRollbackAfterRollbackTo(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
+	blk := file.NewBlockId("testfile", 0)
+
+	tx1, _ := tx.NewTransaction(fm, lm, bm, shared)
+	tx1.Pin(blk)
+	tx1.SetInt(blk, 0, 1)
+	tx1.Savepoint("a")
+	tx1.SetInt(blk, 0, 2)
+	tx1.RollbackTo("a")
+	tx1.SetInt(blk, 0, 3)
+
+	// When
+	err = tx1.Rollback()
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tx2, _ := tx.NewTransaction(fm, lm, bm, shared)
+	tx2.Pin(blk)
+	if i, _ := tx2.GetInt(blk, 0); i != 0 {
+		t.Errorf("Expected 0, got %d", i)
+	}
+}
+
+func TestReleaseSavepoint(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
+	blk := file.NewBlockId("testfile", 0)
+
+	tx1, _ := tx.NewTransaction(fm, lm, bm, shared)
+	tx1.Pin(blk)
+	tx1.Savepoint("a")
+	tx1.SetInt(blk, 0, 1)
+	tx1.Savepoint("b")
+
+	// When
+	err = tx1.Release("a")
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := tx1.RollbackTo("a"); !errors.Is(err, tx.ErrSavepointNotFound) {
+		t.Errorf("Expected ErrSavepointNotFound for a, got %v", err)
+	}
+	if err := tx1.Release("b"); !errors.Is(err, tx.ErrSavepointNotFound) {
+		t.Errorf("Expected ErrSavepointNotFound for b, got %v", err)
+	}
+	if i, _ := tx1.GetInt(blk, 0); i != 1 {
+		t.Errorf("Expected 1, got %d", i)
+	}
+}
+
+func TestSavepointRecord(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := tx.WriteSavepointRecordToLog(lm, 7, "retry"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	var rec tx.LogRecord
+	for bytes, err := range lm.Iterator() {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		rec, err = tx.CreateLogRecord(bytes)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		break
+	}
+
+	// Then
+	if rec.Op() != tx.SAVEPOINT || rec.TxNumber() != 7 || rec.ToString() != "<SAVEPOINT 7 retry>" {
+		t.Errorf("Expected <SAVEPOINT 7 retry>, got %s", rec.ToString())
+	}
+}
+
+// failingStore は fail が立っている間は testfile への書き込みを、
+// failLog が立っている間は logfile の読み込みを失敗させる。
+type failingStore struct {
+	*file.MemStore
+	fail bool
+	failLog bool
+}
+
+var (
+	errWriteFailed = errors.New("write failed")
+	errReadFailed = errors.New("read failed")
+)
+
+func (s *failingStore) ReadAt(filename string, b []byte, off int64) (int, error) {
+	if s.failLog && filename == "logfile" {
+		return 0, errReadFailed
+	}
+	return s.MemStore.ReadAt(filename, b, off)
+}
+
+func (s *failingStore) WriteAt(filename string, b []byte, off int64) (int, error) {
+	if s.fail && filename == "testfile" {
+		return 0, errWriteFailed
+	}
+	return s.MemStore.WriteAt(filename, b, off)
+}
+
+func TestFailedEndReleasesLocksAndPins(t *testing.T) {
+	tests := []struct {
+		name string
+		end func(tx1 *tx.Transaction) error
+	}{
+		{"commit", (*tx.Transaction).Commit},
+		{"rollback", (*tx.Transaction).Rollback},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			store := &failingStore{MemStore: file.NewMemStore()}
+			fm, err := file.NewFileMgrWithStore(store, 400)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			defer cleanup(fm)
+
+			lm, err := log.NewLogMgr(fm, "logfile")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			bm := buffer.NewBufferMgr(fm, lm, 3)
+			shared := tx.NewShared(concurrency.WithMaxWait(10 * time.Millisecond))
+			blk := file.NewBlockId("testfile", 0)
+
+			tx1, _ := tx.NewTransaction(fm, lm, bm, shared)
+			tx1.Pin(blk)
+			if err := tx1.SetInt(blk, 0, 42); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			store.fail = true
+
+			// When
+			err = tt.end(tx1)
+
+			// Then
+			if !errors.Is(err, errWriteFailed) {
+				t.Fatalf("Expected write failed error, got %v", err)
+			}
+			if bm.Available() != 3 {
+				t.Errorf("Expected all buffers unpinned, got %d available", bm.Available())
+			}
+			tx2, _ := tx.NewTransaction(fm, lm, bm, shared)
+			tx2.Pin(blk)
+			i, err := tx2.GetInt(blk, 0)
+			if err != nil {
+				t.Fatalf("Expected lock to be released, got %v", err)
+			}
+			if i != 0 {
+				t.Errorf("Expected failed change to be undone, got %d", i)
+			}
+			// 終わったトランザクションの Rollback は tx2 が読んでいるページを変えない
+			if err := tx1.Rollback(); err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if i, _ := tx2.GetInt(blk, 0); i != 0 {
+				t.Errorf("Expected 0, got %d", i)
+			}
+		})
+	}
+}
+
+func TestFailedUndoKeepsLocks(t *testing.T) {
+	// Given
+	store := &failingStore{MemStore: file.NewMemStore()}
+	fm, err := file.NewFileMgrWithStore(store, 400)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared(concurrency.WithMaxWait(10 * time.Millisecond))
+	blk := file.NewBlockId("testfile", 0)
+
+	tx1, _ := tx.NewTransaction(fm, lm, bm, shared)
+	tx1.Pin(blk)
+	if err := tx1.SetInt(blk, 0, 42); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	store.fail = true
+	store.failLog = true // undo のためにログを読めない
+
+	// When
+	err = tx1.Commit()
+
+	// Then
+	if !errors.Is(err, errWriteFailed) || !errors.Is(err, errReadFailed) {
+		t.Fatalf("Expected write and read failed errors, got %v", err)
+	}
+	tx2, _ := tx.NewTransaction(fm, lm, bm, shared)
+	tx2.Pin(blk)
+	if _, err := tx2.GetInt(blk, 0); !errors.Is(err, concurrency.ErrLockAbort) {
+		t.Errorf("Expected ErrLockAbort while the page is not undone, got %v", err)
+	}
+
+	// ログを読めるようになれば Rollback で取り消してロックを外せる
+	store.fail = false
+	store.failLog = false
+	if err := tx1.Rollback(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	i, err := tx2.GetInt(blk, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if i != 0 {
+		t.Errorf("Expected 0, got %d", i)
+	}
+}
+
+func TestSeparateSharedDoNotShareLocks(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared1 := tx.NewShared(concurrency.WithMaxWait(10 * time.Millisecond))
+	shared2 := tx.NewShared(concurrency.WithMaxWait(10 * time.Millisecond))
+	blk := file.NewBlockId("testfile", 0)
+
+	writer, _ := tx.NewTransaction(fm, lm, bm, shared1)
+	writer.Pin(blk)
+	if err := writer.SetInt(blk, 0, 42); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// When
+	same, _ := tx.NewTransaction(fm, lm, bm, shared1)
+	same.Pin(blk)
+	_, errSame := same.GetInt(blk, 0)
+	other, _ := tx.NewTransaction(fm, lm, bm, shared2)
+	other.Pin(blk)
+	_, errOther := other.GetInt(blk, 0)
+
+	// Then
+	if !errors.Is(errSame, concurrency.ErrLockAbort) {
+		t.Errorf("Expected ErrLockAbort with the same shared, got %v", errSame)
+	}
+	if errOther != nil {
+		t.Errorf("Expected no error with another shared, got %v", errOther)
+	}
+}
+
+func TestIntOffsetOutOfRange(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []tx.Option
+	}{
+		{"locking", nil},
+		{"snapshot", []tx.Option{tx.WithSnapshot()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			fm := setup(t, 400)
+			defer cleanup(fm)
+
+			lm, err := log.NewLogMgr(fm, "logfile")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			bm := buffer.NewBufferMgr(fm, lm, 3)
+			shared := tx.NewShared()
+			blk := file.NewBlockId("testfile", 0)
+
+			tx1, _ := tx.NewTransaction(fm, lm, bm, shared, tt.opts...)
+			tx1.Pin(blk)
+
+			// When
+			_, getErr := tx1.GetInt(blk, 398)
+			setErr := tx1.SetInt(blk, -1, 5)
+
+			// Then
+			if !errors.Is(getErr, file.ErrOffsetOutOfRange) {
+				t.Errorf("Expected ErrOffsetOutOfRange, got %v", getErr)
+			}
+			if !errors.Is(setErr, file.ErrOffsetOutOfRange) {
+				t.Errorf("Expected ErrOffsetOutOfRange, got %v", setErr)
+			}
+		})
+	}
+}
+
+func TestAppendTwice(t *testing.T) {
+	// Given
+	fm := setup(t, 400)
+	defer cleanup(fm)
+
+	lm, err := log.NewLogMgr(fm, "logfile")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bm := buffer.NewBufferMgr(fm, lm, 3)
+	shared := tx.NewShared()
+	tx1, _ := tx.NewTransaction(fm, lm, bm, shared)
+
+	// When
+	blk1, err1 := tx1.Append("testfile")
+	blk2, err2 := tx1.Append("testfile")
+	size, err3 := tx1.Size("testfile")
+
+	// Then
+	for _, err := range []error{err1, err2, err3} {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if blk1.Number() != 0 || blk2.Number() != 1 {
+		t.Errorf("Expected blocks 0 and 1, got %d and %d", blk1.Number(), blk2.Number())
+	}
+	if size != 2 {
+		t.Errorf("Expected size 2, got %d", size)
+	}
+}